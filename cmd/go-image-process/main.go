@@ -7,6 +7,8 @@ import (
 	"github.com/go-kratos/kratos/v2/config/file"
 	"go-image-process/internal/conf"
 	"go-image-process/internal/logging"
+	"go-image-process/internal/style"
+	"google.golang.org/protobuf/proto"
 	"gopkg.in/yaml.v3"
 	"os"
 
@@ -50,6 +52,20 @@ func newApp(hs *http.Server) *kratos.App {
 	)
 }
 
+// redact returns a copy of the config that is safe to print, the storage credentials are masked.
+func redact(bc *conf.Bootstrap) *conf.Bootstrap {
+	redacted := proto.Clone(bc).(*conf.Bootstrap)
+	if s3 := redacted.GetStorage().GetS3(); s3 != nil {
+		if len(s3.SecretAccessKey) > 0 {
+			s3.SecretAccessKey = "******"
+		}
+		if len(s3.SessionToken) > 0 {
+			s3.SessionToken = "******"
+		}
+	}
+	return redacted
+}
+
 func main() {
 
 	flag.Parse()
@@ -79,7 +95,7 @@ func main() {
 	if err := c.Scan(&bc); err != nil {
 		panic(err)
 	}
-	fmt.Println(bc.String())
+	fmt.Println(redact(&bc).String())
 	if len(httpServerPort) > 0 {
 		bc.GetServer().GetHttp().Addr = fmt.Sprintf("0.0.0.0:%s", httpServerPort)
 	}
@@ -88,7 +104,27 @@ func main() {
 		bc.Image = &conf.Image{Quality: 100}
 	}
 
-	app, cleanup, err := initApp(&bc)
+	styles, err := style.NewRegistry(bc.GetImage().GetStyles())
+	if err != nil {
+		panic(err)
+	}
+	//配置文件变更时重新加载样式，新样式有错误时继续使用旧样式
+	if err := c.Watch("image.styles", func(key string, value config.Value) {
+		var next conf.Bootstrap
+		if err := c.Scan(&next); err != nil {
+			log.Errorf("scan config error: %v", err)
+			return
+		}
+		if err := styles.Update(next.GetImage().GetStyles()); err != nil {
+			log.Errorf("reload styles error: %v", err)
+			return
+		}
+		log.Infof("reloaded %d styles", len(next.GetImage().GetStyles()))
+	}); err != nil {
+		log.Warnf("styles are not configured, add image.styles to enable hot reload: %v", err)
+	}
+
+	app, cleanup, err := initApp(&bc, styles)
 	if err != nil {
 		panic(err)
 	}
@@ -9,11 +9,14 @@ import (
 	"github.com/go-kratos/kratos/v2"
 	"github.com/google/wire"
 	"go-image-process/internal/conf"
+	"go-image-process/internal/fetch"
 	"go-image-process/internal/server"
 	"go-image-process/internal/service"
+	"go-image-process/internal/storage"
+	"go-image-process/internal/style"
 )
 
 // initApp init kratos application.
-func initApp(*conf.Bootstrap) (*kratos.App, func(), error) {
-	panic(wire.Build(server.ProviderSet, service.ProviderSet, newApp))
+func initApp(*conf.Bootstrap, *style.Registry) (*kratos.App, func(), error) {
+	panic(wire.Build(server.ProviderSet, service.ProviderSet, storage.ProviderSet, fetch.ProviderSet, newApp))
 }
@@ -9,15 +9,26 @@ package main
 import (
 	"github.com/go-kratos/kratos/v2"
 	"go-image-process/internal/conf"
+	"go-image-process/internal/fetch"
 	"go-image-process/internal/server"
 	"go-image-process/internal/service"
+	"go-image-process/internal/storage"
+	"go-image-process/internal/style"
 )
 
 // Injectors from wire.go:
 
 // initApp init kratos application.
-func initApp(bootstrap *conf.Bootstrap) (*kratos.App, func(), error) {
-	imageInterface, cleanup := service.NewImage(bootstrap)
+func initApp(bootstrap *conf.Bootstrap, registry *style.Registry) (*kratos.App, func(), error) {
+	storageStorage, err := storage.NewStorage(bootstrap)
+	if err != nil {
+		return nil, nil, err
+	}
+	fetcher := fetch.NewFetcher(bootstrap)
+	imageInterface, cleanup, err := service.NewImage(bootstrap, storageStorage, fetcher, registry)
+	if err != nil {
+		return nil, nil, err
+	}
 	httpServer := server.NewHTTPServer(bootstrap, imageInterface)
 	app := newApp(httpServer)
 	return app, func() {
@@ -0,0 +1,225 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/google/wire"
+	"go-image-process/internal/conf"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ProviderSet is fetch providers.
+var ProviderSet = wire.NewSet(NewFetcher)
+
+const (
+	defaultMaxSize        = 20 << 20
+	defaultConnectTimeout = 3 * time.Second
+	defaultReadTimeout    = 10 * time.Second
+)
+
+var (
+	ErrFetchDisabled     = errors.Forbidden("FetchDisabled", "Fetching remote images is not enabled.")
+	ErrInvalidURL        = errors.BadRequest("InvalidURL", "The url must be an absolute http or https url.")
+	ErrDomainNotAllowed  = errors.Forbidden("DomainNotAllowed", "The url host is not in the allowed domains.")
+	ErrAddressNotAllowed = errors.Forbidden("AddressNotAllowed", "The url resolves to a non public address.")
+	ErrTooManyRedirects  = errors.BadRequest("TooManyRedirects", "The url redirects too many times.")
+	ErrEntityTooLarge    = errors.BadRequest("EntityTooLarge", "The remote image exceeds the maximum allowed size.")
+)
+
+// reservedBlocks are the special purpose ranges net.IP has no predicate for.
+var reservedBlocks = parseCIDRs(
+	"0.0.0.0/8",     // this network
+	"100.64.0.0/10", // carrier grade nat
+	"192.0.0.0/24",  // ietf protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, including broadcast
+	"64:ff9b::/96",  // nat64, maps to ipv4 addresses
+	"fec0::/10",     // deprecated site local
+	"2001:db8::/32", // documentation
+)
+
+// Fetcher downloads source images from remote urls. Only hosts of the allow-list are fetched, and the
+// addresses they resolve to are checked when connecting so that neither dns nor redirects can reach
+// the internal network.
+type Fetcher struct {
+	allowedDomains []string
+	maxSize        int64
+	maxRedirects   int
+	client         *http.Client
+}
+
+func NewFetcher(bootstrap *conf.Bootstrap) *Fetcher {
+	fetchConf := bootstrap.GetFetch()
+	f := &Fetcher{
+		maxSize:      fetchConf.GetMaxSize(),
+		maxRedirects: int(fetchConf.GetMaxRedirects()),
+	}
+	for _, domain := range fetchConf.GetAllowedDomains() {
+		if domain = strings.ToLower(strings.Trim(domain, ". ")); len(domain) > 0 {
+			f.allowedDomains = append(f.allowedDomains, domain)
+		}
+	}
+	if f.maxSize <= 0 {
+		f.maxSize = defaultMaxSize
+	}
+	connectTimeout, readTimeout := defaultConnectTimeout, defaultReadTimeout
+	if fetchConf.GetConnectTimeout() != nil {
+		connectTimeout = fetchConf.GetConnectTimeout().AsDuration()
+	}
+	if fetchConf.GetReadTimeout() != nil {
+		readTimeout = fetchConf.GetReadTimeout().AsDuration()
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	if !fetchConf.GetAllowPrivateIp() {
+		dialer.Control = checkAddress
+	}
+	f.client = &http.Client{
+		Transport: &http.Transport{
+			//不走代理，否则连接检查看到的是代理的地址
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: connectTimeout,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: f.checkRedirect,
+		Timeout:       readTimeout + connectTimeout,
+	}
+	return f
+}
+
+// Fetch starts downloading rawURL, the returned body fails with ErrEntityTooLarge once more than
+// the maximum size has been read.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+	if len(f.allowedDomains) == 0 {
+		return nil, ErrFetchDisabled
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if err := f.checkURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		var se *errors.Error
+		if errors.As(err, &se) {
+			return nil, se
+		}
+		//url.Error的信息里带有完整的url，只保留底层的错误
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, errors.New(http.StatusBadGateway, "FetchFailed", fmt.Sprintf("fetch %s: %v", Origin(rawURL), err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, errors.New(http.StatusBadGateway, "FetchFailed", fmt.Sprintf("fetch %s: %s", Origin(rawURL), resp.Status))
+	}
+	if resp.ContentLength > f.maxSize {
+		_ = resp.Body.Close()
+		return nil, ErrEntityTooLarge
+	}
+	return &limitedBody{ReadCloser: resp.Body, remaining: f.maxSize}, nil
+}
+
+// Origin returns the scheme and host of rawURL for logs and error messages, the path and query are
+// left out as they may carry signatures or tokens.
+func Origin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Host) == 0 {
+		return "invalid url"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// checkURL only lets http and https urls of allowed hosts through.
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 || u.User != nil {
+		return ErrInvalidURL
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, domain := range f.allowedDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return ErrDomainNotAllowed
+}
+
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > f.maxRedirects {
+		return ErrTooManyRedirects
+	}
+	return f.checkURL(req.URL)
+}
+
+// checkAddress runs after dns resolution for every connection, including the ones of redirects.
+func checkAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrAddressNotAllowed
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, block := range reservedBlocks {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var blocks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// limitedBody stops a download that turns out larger than announced by Content-Length.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, ErrEntityTooLarge
+	}
+	return n, err
+}
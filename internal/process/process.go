@@ -0,0 +1,294 @@
+package process
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is one step of an x-oss-process chain such as resize,w_100,h_100. Every parameter has
+// been checked against the schema of the operation, so the typed accessors never fail.
+type Operation struct {
+	Name   string
+	Params []Param
+	// Offset is the byte offset of the operation in the process string.
+	Offset int
+}
+
+// Param is a key_value parameter, or the single value of operations like rotate,90 whose Key is empty.
+type Param struct {
+	Key   string
+	Raw   string
+	Int   int
+	Float float64
+	// Str is the decoded text of base64 parameters and Raw otherwise.
+	Str    string
+	Offset int
+}
+
+// Has reports whether the parameter is given.
+func (o Operation) Has(key string) bool {
+	_, ok := o.param(key)
+	return ok
+}
+
+// Int returns an integer parameter, or def when it is not given.
+func (o Operation) Int(key string, def int) int {
+	if p, ok := o.param(key); ok {
+		return p.Int
+	}
+	return def
+}
+
+// Float returns a numeric parameter, or def when it is not given.
+func (o Operation) Float(key string, def float64) float64 {
+	if p, ok := o.param(key); ok {
+		return p.Float
+	}
+	return def
+}
+
+// String returns a text parameter, base64 parameters are decoded, or def when it is not given.
+func (o Operation) String(key string, def string) string {
+	if p, ok := o.param(key); ok {
+		return p.Str
+	}
+	return def
+}
+
+// Value returns the single value of operations like rotate,90 or format,webp.
+func (o Operation) Value() Param {
+	p, _ := o.param("")
+	return p
+}
+
+func (o Operation) param(key string) (Param, bool) {
+	for _, p := range o.Params {
+		if p.Key == key {
+			return p, true
+		}
+	}
+	return Param{}, false
+}
+
+// Parse turns an x-oss-process string into its operations. The leading image/ is optional, every
+// operation and parameter is validated, and errors name the offending token and its offset.
+func Parse(process string) ([]Operation, error) {
+	offset := 0
+	if strings.HasPrefix(process, "image/") {
+		offset = len("image/")
+	}
+	if offset == len(process) {
+		return nil, nil
+	}
+
+	var operations []Operation
+	for _, segment := range strings.Split(process[offset:], "/") {
+		op, err := parseOperation(segment, offset)
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+		offset += len(segment) + 1
+	}
+	return operations, nil
+}
+
+// Format is the inverse of Parse, it writes the operations back as an image/<ops> string.
+func Format(operations []Operation) string {
+	var b strings.Builder
+	b.WriteString("image/")
+	for i, op := range operations {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(op.Name)
+		for _, p := range op.Params {
+			b.WriteByte(',')
+			if len(p.Key) > 0 {
+				b.WriteString(p.Key + "_")
+			}
+			b.WriteString(p.Raw)
+		}
+	}
+	return b.String()
+}
+
+func parseOperation(segment string, offset int) (Operation, error) {
+	tokens := strings.Split(segment, ",")
+	op := Operation{Name: tokens[0], Offset: offset}
+	if len(op.Name) == 0 {
+		return op, newError("MissingArgument", segment, offset, "empty operation")
+	}
+	schema, ok := schemas[op.Name]
+	if !ok {
+		return op, newError("InvalidArgument", op.Name, offset, "unknown operation %s", op.Name)
+	}
+
+	offset += len(op.Name) + 1
+	for _, token := range tokens[1:] {
+		p, err := parseParam(op.Name, schema, token, offset)
+		if err != nil {
+			return op, err
+		}
+		if op.Has(p.Key) {
+			return op, newError("InvalidArgument", token, offset, "duplicate parameter %s of %s", displayKey(p.Key), op.Name)
+		}
+		op.Params = append(op.Params, p)
+		offset += len(token) + 1
+	}
+
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if schema[key].required && !op.Has(key) {
+			return op, newError("MissingArgument", segment, op.Offset, "missing required parameter %s of %s", displayKey(key), op.Name)
+		}
+	}
+	return op, nil
+}
+
+func parseParam(name string, schema map[string]spec, token string, offset int) (Param, error) {
+	p := Param{Raw: token, Offset: offset}
+	s, single := schema[""]
+	if !single {
+		var ok bool
+		p.Key, p.Raw, ok = strings.Cut(token, "_")
+		if !ok || len(p.Key) == 0 {
+			return p, newError("InvalidArgument", token, offset, "parameter %s of %s is not key_value", token, name)
+		}
+		if s, ok = schema[p.Key]; !ok {
+			return p, newError("InvalidArgument", token, offset, "unknown parameter %s of %s", p.Key, name)
+		}
+	}
+	if len(p.Raw) == 0 {
+		return p, newError("InvalidArgument", token, offset, "empty value of %s", displayKey(p.Key))
+	}
+	if err := s.check(&p); err != nil {
+		return p, newError("InvalidArgument", token, offset, "%s of %s %s", displayKey(p.Key), name, err.Error())
+	}
+	return p, nil
+}
+
+func displayKey(key string) string {
+	if len(key) == 0 {
+		return "value"
+	}
+	return key
+}
+
+// newError builds the OSS style error, the metadata carries the token and its offset for clients.
+func newError(code string, token string, offset int, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	return errors.BadRequest(code, fmt.Sprintf("%s (%q at offset %d).", message, token, offset)).
+		WithMetadata(map[string]string{"token": token, "offset": strconv.Itoa(offset)})
+}
+
+type kind int
+
+const (
+	kindInt kind = iota
+	kindFloat
+	kindEnum
+	kindColor
+	kindBase64
+)
+
+// spec describes the accepted values of a parameter.
+type spec struct {
+	kind     kind
+	min      float64
+	max      float64
+	values   []string
+	alpha    bool
+	required bool
+}
+
+func intRange(min, max int) spec {
+	return spec{kind: kindInt, min: float64(min), max: float64(max)}
+}
+
+func floatRange(min, max float64) spec {
+	return spec{kind: kindFloat, min: min, max: max}
+}
+
+func enum(values ...string) spec {
+	return spec{kind: kindEnum, values: values}
+}
+
+func color() spec {
+	return spec{kind: kindColor}
+}
+
+func base64Text() spec {
+	return spec{kind: kindBase64}
+}
+
+func (s spec) withAlpha() spec {
+	s.alpha = true
+	return s
+}
+
+func (s spec) isRequired() spec {
+	s.required = true
+	return s
+}
+
+func (s spec) check(p *Param) error {
+	p.Str = p.Raw
+	switch s.kind {
+	case kindInt:
+		v, err := strconv.Atoi(p.Raw)
+		if err != nil || float64(v) < s.min || float64(v) > s.max {
+			return fmt.Errorf("must be an integer between %d and %d", int(s.min), int(s.max))
+		}
+		p.Int, p.Float = v, float64(v)
+	case kindFloat:
+		v, err := strconv.ParseFloat(p.Raw, 64)
+		if err != nil || math.IsNaN(v) || v < s.min || v > s.max {
+			return fmt.Errorf("must be a number between %g and %g", s.min, s.max)
+		}
+		p.Float = v
+	case kindEnum:
+		for _, value := range s.values {
+			if p.Raw == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(s.values, ", "))
+	case kindColor:
+		if !isHex(p.Raw) || (len(p.Raw) != 6 && !(s.alpha && len(p.Raw) == 8)) {
+			if s.alpha {
+				return fmt.Errorf("must be a RRGGBB or RRGGBBAA hex color")
+			}
+			return fmt.Errorf("must be a RRGGBB hex color")
+		}
+	case kindBase64:
+		//OSS要求URL安全的base64，兼容末尾的=以及标准base64中的+
+		raw := strings.TrimRight(p.Raw, "=")
+		buf, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			if buf, err = base64.RawStdEncoding.DecodeString(raw); err != nil {
+				return fmt.Errorf("must be url safe base64")
+			}
+		}
+		p.Str = string(buf)
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package process
+
+import (
+	"github.com/go-kratos/kratos/v2/errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	ops, err := Parse("image/resize,w_100,m_fill/rotate,90/watermark,text_SGVsbG8gV29ybGQ,color_FF000080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 3 {
+		t.Fatalf("got %d operations, want 3", len(ops))
+	}
+	if ops[0].Name != "resize" || ops[0].Int("w", 0) != 100 || ops[0].String("m", "") != "fill" || ops[0].Int("h", -1) != -1 {
+		t.Errorf("resize parsed as %+v", ops[0])
+	}
+	if ops[1].Offset != 26 || ops[1].Value().Int != 90 || ops[1].Value().Offset != 33 {
+		t.Errorf("rotate parsed as %+v", ops[1])
+	}
+	if text := ops[2].String("text", ""); text != "Hello World" {
+		t.Errorf("text decoded as %q", text)
+	}
+
+	for _, process := range []string{"", "image/"} {
+		if ops, err := Parse(process); err != nil || ops != nil {
+			t.Errorf("Parse(%q) = %v, %v, want no operations", process, ops, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		process string
+		reason  string
+		token   string
+		offset  string
+	}{
+		{"image/resize,w_100/foo,x_1", "InvalidArgument", "foo", "19"},
+		{"resize,w_100/foo", "InvalidArgument", "foo", "13"},
+		{"image/resize,z_1", "InvalidArgument", "z_1", "13"},
+		{"image/resize,w", "InvalidArgument", "w", "13"},
+		{"image/resize,w_", "InvalidArgument", "w_", "13"},
+		{"image/resize,w_1,h_2,w_3", "InvalidArgument", "w_3", "21"},
+		{"image/rotate,90,180", "InvalidArgument", "180", "16"},
+		{"image/rotate,361", "InvalidArgument", "361", "13"},
+		{"image/quality,q_0", "InvalidArgument", "q_0", "14"},
+		{"image/resize,w_1.5", "InvalidArgument", "w_1.5", "13"},
+		{"image/blur,r_3,s_51", "InvalidArgument", "s_51", "15"},
+		{"image/blur,r_3,s_NaN", "InvalidArgument", "s_NaN", "15"},
+		{"image/format,bpg", "InvalidArgument", "bpg", "13"},
+		{"image/watermark,color_12345", "InvalidArgument", "color_12345", "16"},
+		{"image/watermark,text_!!!", "InvalidArgument", "text_!!!", "16"},
+		{"image/resize,w_1/watermark,image_a*b", "InvalidArgument", "image_a*b", "27"},
+		{"image/blur,r_3", "MissingArgument", "blur,r_3", "6"},
+		{"image/rotate", "MissingArgument", "rotate", "6"},
+		{"image/resize,w_1/", "MissingArgument", "", "17"},
+		{"image//rotate,90", "MissingArgument", "", "6"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.process)
+		se := errors.FromError(err)
+		if err == nil || se.Code != 400 || se.Reason != tt.reason {
+			t.Errorf("Parse(%q) = %v, want %s", tt.process, err, tt.reason)
+			continue
+		}
+		if se.Metadata["token"] != tt.token || se.Metadata["offset"] != tt.offset {
+			t.Errorf("Parse(%q) reports %q at %s, want %q at %s", tt.process,
+				se.Metadata["token"], se.Metadata["offset"], tt.token, tt.offset)
+		}
+	}
+}
+
+func TestParseBase64(t *testing.T) {
+	//URL安全的编码、带=的编码以及标准编码都能解出同一段文字
+	for _, raw := range []string{"5rC05Y2w", "5rC05Y2w==", "Pz8-", "Pz8+"} {
+		ops, err := Parse("image/watermark,text_" + raw)
+		if err != nil {
+			t.Errorf("text_%s: %v", raw, err)
+			continue
+		}
+		if len(ops[0].String("text", "")) == 0 {
+			t.Errorf("text_%s decoded to nothing", raw)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"image/",
+		"image/resize,w_100,h_100,m_fill,limit_0,color_FFFFFF",
+		"image/crop,x_10,y_10,w_200,h_200,g_se/rotate,90/format,webp",
+		"image/watermark,text_SGVsbG8,type_d3F5LXplbmhlaQ,color_FF000080,size_30,g_center,voffset_-10",
+		"image/blur,r_3,s_2/bright,-50/contrast,50/sharpen,100/quality,q_80",
+		"resize,p_50/info",
+		"image/resize,w_1/",
+		"image//rotate,90",
+		"image/resize,w_1,w_2",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, process string) {
+		ops, err := Parse(process)
+		if err != nil {
+			if se := errors.FromError(err); se.Code != 400 || len(se.Metadata["offset"]) == 0 {
+				t.Fatalf("Parse(%q) returned an unexpected error %v", process, err)
+			}
+			return
+		}
+		if len(ops) == 0 {
+			return
+		}
+
+		formatted := Format(ops)
+		if want := "image/" + strings.TrimPrefix(process, "image/"); formatted != want {
+			t.Fatalf("Format(Parse(%q)) = %q, want %q", process, formatted, want)
+		}
+		again, err := Parse(formatted)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed after a round trip: %v", formatted, err)
+		}
+		if !strings.HasPrefix(process, "image/") {
+			shift(again, -len("image/"))
+		}
+		if !reflect.DeepEqual(ops, again) {
+			t.Fatalf("Parse(%q) = %+v, after a round trip %+v", process, ops, again)
+		}
+	})
+}
+
+func shift(ops []Operation, delta int) {
+	for i := range ops {
+		ops[i].Offset += delta
+		for j := range ops[i].Params {
+			ops[i].Params[j].Offset += delta
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package process
+
+import "math"
+
+var gravity = enum("nw", "north", "ne", "west", "center", "east", "sw", "south", "se")
+
+// schemas lists the parameters of every supported operation, the key "" is the single value of
+// operations written as name,value. Rules spanning several parameters are left to the operations.
+var schemas = map[string]map[string]spec{
+	"info":        {},
+	"average-hue": {},
+	"resize": {
+		"w":     intRange(1, 16384),
+		"h":     intRange(1, 16384),
+		"l":     intRange(1, 16384),
+		"s":     intRange(1, 16384),
+		"p":     intRange(1, 1000),
+		"P":     intRange(1, 100),
+		"m":     enum("lfit", "mfit", "fill", "pad", "fixed"),
+		"limit": intRange(0, 1),
+		"color": color(),
+	},
+	"watermark": {
+		"text":        base64Text(),
+		"type":        base64Text(),
+		"image":       base64Text(),
+		"color":       color().withAlpha(),
+		"size":        intRange(1, 1000),
+		"t":           intRange(0, 100),
+		"fill":        intRange(0, 1),
+		"rotate":      intRange(0, 360),
+		"g":           gravity,
+		"x":           intRange(0, 4096),
+		"y":           intRange(0, 4096),
+		"voffset":     intRange(-1000, 1000),
+		"padx":        intRange(0, 4096),
+		"pady":        intRange(0, 4096),
+		"order":       intRange(0, 1),
+		"align":       intRange(0, 2),
+		"interval":    intRange(0, 1000),
+		"P":           intRange(1, 100),
+		"shadow":      intRange(0, 100),
+		"stroke":      intRange(0, 20),
+		"strokecolor": color(),
+	},
+	"blur": {
+		"r": floatRange(0, 50).isRequired(),
+		"s": floatRange(0, 50).isRequired(),
+	},
+	"crop": {
+		"w": intRange(0, math.MaxInt32),
+		"h": intRange(0, math.MaxInt32),
+		"x": intRange(0, math.MaxInt32),
+		"y": intRange(0, math.MaxInt32),
+		"g": gravity,
+	},
+	"indexcrop": {
+		"x": intRange(1, math.MaxInt32),
+		"y": intRange(1, math.MaxInt32),
+		"i": intRange(0, math.MaxInt32),
+	},
+	"quality": {
+		"q": intRange(1, 100),
+		"Q": intRange(1, 100),
+	},
+	"format":          {"": enum("jpg", "jpeg", "png", "webp", "tiff", "gif", "avif", "heic", "heif", "jxl", "bmp", "jp2").isRequired()},
+	"auto-orient":     {"": enum("0", "1").isRequired()},
+	"interlace":       {"": enum("0", "1").isRequired()},
+	"circle":          {"r": intRange(1, 4096)},
+	"rounded-corners": {"r": intRange(1, 4096).isRequired()},
+	"rotate":          {"": intRange(0, 360).isRequired()},
+	"bright":          {"": intRange(-100, 100).isRequired()},
+	"contrast":        {"": intRange(-100, 100).isRequired()},
+	"sharpen":         {"": intRange(50, 399).isRequired()},
+	"metadata":        {"": enum("all", "icc", "privacy").isRequired()},
+}
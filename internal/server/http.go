@@ -42,6 +42,8 @@ func NewHTTPServer(
 		return context.String(http.StatusOK, "success")
 	})
 	router.POST("/image", handler(image.ImageHandler))
+	router.GET("/fetch", handler(image.FetchHandler))
+	router.GET("/{bucket}/{object:.+}", handler(image.ObjectHandler))
 	return srv
 }
 
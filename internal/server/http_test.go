@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"go-image-process/internal/conf"
+	"go-image-process/internal/service"
+	"go-image-process/internal/storage"
+	"go-image-process/internal/style"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestObjectRoute serves objects of a local storage through GET /{bucket}/{object}.
+func TestObjectRoute(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "photos", "2024"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 16), G: 128, B: 64, A: 255})
+		}
+	}
+	var original bytes.Buffer
+	if err := png.Encode(&original, img); err != nil {
+		t.Fatal(err)
+	}
+	//对象名没有扩展名，类型只能从文件头判断
+	if err := os.WriteFile(filepath.Join(root, "photos", "2024", "a"), original.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrap := &conf.Bootstrap{
+		Server: &conf.Server{Http: &conf.Server_HTTP{}},
+		Image:  &conf.Image{Quality: 80},
+	}
+	styles, err := style.NewRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageService, cleanup, err := service.NewImage(bootstrap, storage.NewLocal(root), nil, styles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	srv := NewHTTPServer(bootstrap, imageService)
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	res := get("/photos/2024/a")
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("original: %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	if !bytes.Equal(res.Body.Bytes(), original.Bytes()) {
+		t.Errorf("original: the body is not the stored object")
+	}
+
+	res = get("/photos/2024/a?x-oss-process=image/resize,w_4/format,jpg")
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/jpeg" {
+		t.Errorf("processed: %d %s %s", res.Code, res.Header().Get("Content-Type"), res.Body.String())
+	}
+	if processed, err := jpeg.Decode(res.Body); err != nil || processed.Bounds().Dx() != 4 || processed.Bounds().Dy() != 2 {
+		t.Errorf("processed: %v, %v, want a 4x2 jpeg", processed, err)
+	}
+
+	res = get("/photos/2024/missing.jpg")
+	var reply ErrReply
+	if err := json.Unmarshal(res.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("missing: %v %s", err, res.Body.String())
+	}
+	if reply.Code != http.StatusNotFound || reply.Reason != "NoSuchKey" {
+		t.Errorf("missing: %+v, want 404 NoSuchKey", reply)
+	}
+}
@@ -1,14 +1,18 @@
 package service
 
 import (
+	"bufio"
 	bytes2 "bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
 	errors2 "github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/log"
 	transportHttp "github.com/go-kratos/kratos/v2/transport/http"
 	"go-image-process/internal/conf"
+	"go-image-process/internal/fetch"
+	"go-image-process/internal/process"
+	"go-image-process/internal/storage"
+	"go-image-process/internal/style"
 	"go-image-process/internal/vips"
 	_ "golang.org/x/image/bmp"
 	_ "golang.org/x/image/riff"
@@ -18,7 +22,6 @@ import (
 	"io"
 	"math"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
 )
@@ -26,14 +29,22 @@ import (
 type Image struct {
 	imageConf *conf.Image
 	pool      *sync.Pool
+	storage   storage.Storage
+	fetcher   *fetch.Fetcher
+	styles    *style.Registry
+	fonts     map[string]string
 }
 
-func NewImage(bootstrap *conf.Bootstrap) (ImageInterface, func()) {
+func NewImage(bootstrap *conf.Bootstrap, storage storage.Storage, fetcher *fetch.Fetcher, styles *style.Registry) (ImageInterface, func(), error) {
+	if !isMetadataMode(bootstrap.GetImage().GetMetadata()) {
+		return nil, nil, fmt.Errorf("invalid image metadata config: %s", bootstrap.GetImage().GetMetadata())
+	}
 	if bootstrap.GetVip() != nil {
 		vips.Startup(&vips.Config{
 			ConcurrencyLevel: int(bootstrap.GetVip().GetConcurrencylevel()),
 			MaxCacheMem:      int(bootstrap.GetVip().GetMaxcachemem()),
 			MaxCacheSize:     int(bootstrap.GetVip().GetMaxcachesize()),
+			FontDir:          bootstrap.GetVip().GetFontdir(),
 		})
 	} else {
 		vips.Startup(&vips.Config{
@@ -45,8 +56,17 @@ func NewImage(bootstrap *conf.Bootstrap) (ImageInterface, func()) {
 	vips.LoggingSettings(func(messageDomain string, messageLevel vips.LogLevel, message string) {
 		log.Info(message)
 	}, vips.LogLevelError)
+	if _, ok := bootstrap.GetVip().GetFonts()[defaultWatermarkFont]; !ok {
+		if _, ok := vips.FontFamily(defaultWatermarkFont); !ok {
+			log.Warnf("font %s is not in vip.fontdir or vip.fonts, text watermarks without type_ use %s", defaultWatermarkFont, fallbackWatermarkFont)
+		}
+	}
 	return &Image{
 			imageConf: bootstrap.GetImage(),
+			storage:   storage,
+			fetcher:   fetcher,
+			styles:    styles,
+			fonts:     bootstrap.GetVip().GetFonts(),
 			pool: &sync.Pool{
 				New: func() interface{} {
 					return new(bytes2.Buffer)
@@ -54,38 +74,29 @@ func NewImage(bootstrap *conf.Bootstrap) (ImageInterface, func()) {
 			},
 		}, func() {
 			vips.Shutdown()
-		}
+		}, nil
 }
 
 type PostImageRequest struct {
 	ProcessOpt string `json:"x-oss-process"`
 }
 
+type GetObjectRequest struct {
+	Bucket     string `json:"bucket"`
+	Object     string `json:"object"`
+	ProcessOpt string `json:"x-oss-process"`
+}
+
+type FetchRequest struct {
+	URL        string `json:"url"`
+	ProcessOpt string `json:"x-oss-process"`
+}
+
 func (i Image) ImageHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error) {
 	var req PostImageRequest
 	if err := httpContext.BindQuery(&req); err != nil {
 		return nil, errors2.BadRequest("PARAM_ERROR", err.Error())
 	}
-	m := make(map[string][]string)
-	var operations []operation
-	var formatOperation *operation
-	opts := strings.Split(strings.ReplaceAll(req.ProcessOpt, "image/", ""), "/")
-	isInfo := false
-	for _, s := range opts {
-		split := strings.Split(s, ",")
-		m[split[0]] = split[1:]
-		op := operation{
-			operation: split[0],
-			opt:       split[1:],
-		}
-		if op.operation == "info" {
-			isInfo = true
-		} else if op.operation == "format" {
-			formatOperation = &op
-		} else {
-			operations = append(operations, op)
-		}
-	}
 
 	buf := i.pool.Get().(*bytes2.Buffer)
 	defer func() {
@@ -96,263 +107,727 @@ func (i Image) ImageHandler(ctx context.Context, httpContext transportHttp.Conte
 		log.Context(ctx).Errorf("io copy error: %v", err)
 		return nil, err
 	}
+	return i.process(ctx, httpContext, req.ProcessOpt, i.imageConf.GetWatermarkBucket(), buf)
+}
+
+// ObjectHandler serves an object from storage the way OSS does, processed when x-oss-process is given
+// and as stored otherwise.
+func (i Image) ObjectHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error) {
+	var req GetObjectRequest
+	if err := httpContext.BindVars(&req); err != nil {
+		return nil, errors2.BadRequest("PARAM_ERROR", err.Error())
+	}
+	if err := httpContext.BindQuery(&req); err != nil {
+		return nil, errors2.BadRequest("PARAM_ERROR", err.Error())
+	}
+
+	reader, err := i.storage.Get(ctx, req.Bucket, req.Object)
+	if err != nil {
+		log.Context(ctx).Errorf("storage get %s/%s error: %v", req.Bucket, req.Object, err)
+		return nil, err
+	}
+	defer reader.Close()
+	//与OSS一致，水印图片从所访问对象的bucket中读取
+	return i.serve(ctx, httpContext, reader, req.ProcessOpt, req.Bucket)
+}
+
+// FetchHandler serves an image downloaded from the url parameter like ObjectHandler, only the hosts
+// configured in fetch.allowed_domains can be fetched.
+func (i Image) FetchHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error) {
+	var req FetchRequest
+	if err := httpContext.BindQuery(&req); err != nil {
+		return nil, errors2.BadRequest("PARAM_ERROR", err.Error())
+	}
+	if len(req.URL) == 0 {
+		return nil, errors2.BadRequest("PARAM_ERROR", "Missing required param: url")
+	}
+
+	reader, err := i.fetcher.Fetch(ctx, req.URL)
+	if err != nil {
+		log.Context(ctx).Errorf("fetch %s error: %v", fetch.Origin(req.URL), err)
+		return nil, err
+	}
+	defer reader.Close()
+	return i.serve(ctx, httpContext, reader, req.ProcessOpt, i.imageConf.GetWatermarkBucket())
+}
+
+// serve writes the image read from reader, processed when processOpt is given and as read otherwise.
+// Watermark images are loaded from bucket.
+func (i Image) serve(ctx context.Context, httpContext transportHttp.Context, reader io.Reader, processOpt string, bucket string) (interface{}, error) {
+	//原图不经过缓冲直接转发，只预读文件头来判断类型
+	if len(processOpt) == 0 {
+		body := bufio.NewReader(reader)
+		head, _ := body.Peek(1024)
+		return nil, httpContext.Stream(http.StatusOK, GetMimeTypeByVipImageType(vips.DetermineImageType(head)), body)
+	}
+
+	buf := i.pool.Get().(*bytes2.Buffer)
+	defer func() {
+		buf.Reset()
+		i.pool.Put(buf)
+	}()
+	if _, err := io.Copy(buf, reader); err != nil {
+		log.Context(ctx).Errorf("io copy error: %v", err)
+		return nil, err
+	}
+	return i.process(ctx, httpContext, processOpt, bucket, buf)
+}
+
+// process runs the x-oss-process chain on the image in buf and writes the result, or the info and
+// average-hue json, to httpContext.
+func (i Image) process(ctx context.Context, httpContext transportHttp.Context, processOpt string, bucket string, buf *bytes2.Buffer) (interface{}, error) {
+	processOpt, err := i.styles.Expand(processOpt)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := process.Parse(processOpt)
+	if err != nil {
+		return nil, err
+	}
+	var operations []process.Operation
+	var formatOperation *process.Operation
+	var autoOrientOperation *process.Operation
+	isInfo := false
+	isAverageHue := false
+	for n := range parsed {
+		op := parsed[n]
+		if op.Name == "info" {
+			isInfo = true
+		} else if op.Name == "average-hue" {
+			isAverageHue = true
+		} else if op.Name == "format" {
+			formatOperation = &op
+		} else if op.Name == "auto-orient" {
+			autoOrientOperation = &op
+		} else {
+			operations = append(operations, op)
+		}
+	}
+
 	importParams := vips.NewImportParams()
+	//动图加载全部帧，各帧纵向排列，每帧高度为PageHeight
+	if isAnimatedFormat(vips.DetermineImageType(buf.Bytes())) {
+		importParams.NumPages.Set(-1)
+	}
 	vipImage, err := vips.LoadImageFromBuffer(buf.Bytes(), importParams)
 	if err != nil {
 		log.Context(ctx).Errorf("vips new image from buf error: %v", err)
 		return nil, err
 	}
 	defer vipImage.Close()
+	delay, err := vipImage.PageDelay()
+	if err != nil {
+		log.Context(ctx).Errorf("vips get page delay error: %v", err)
+		return nil, err
+	}
 
 	if isInfo {
-		var info = make(map[string]interface{}, 4)
-		info["FileSize"] = map[string]interface{}{"value": buf.Len()}
-		info["Format"] = map[string]interface{}{"value": vips.ImageTypes[vipImage.Format()]}
-		info["ImageHeight"] = map[string]interface{}{"value": vipImage.Height()}
-		info["ImageWidth"] = map[string]interface{}{"value": vipImage.Width()}
+		info := imageInfo(vipImage, buf.Len())
 		if err := httpContext.JSON(http.StatusOK, info); err != nil {
 			return nil, err
 		}
 		return nil, nil
 	}
 
-	if len(operations) == 0 && formatOperation == nil {
+	if isAverageHue {
+		rgb, err := averageHue(vipImage)
+		if err != nil {
+			log.Context(ctx).Errorf("vips average hue error: %v", err)
+			return nil, err
+		}
+		if err := httpContext.JSON(http.StatusOK, map[string]interface{}{"RGB": rgb}); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if len(operations) == 0 && formatOperation == nil && autoOrientOperation == nil {
 		return nil, errors2.BadRequest("unknown opt", "unknown opt")
 	}
 
-	for _, op := range operations {
-		switch op.operation {
-		case "resize":
-			opt, err := parseResizeOpt(op.opt)
-			if err != nil {
-				log.Context(ctx).Errorf("parse resize opt error: %v", err)
+	//auto-orient作用于原图，不论出现在处理链的哪个位置，都要在缩放、裁剪等操作之前执行
+	if autoOrientOperation != nil {
+		if autoOrientOperation.Value().Raw == "1" {
+			if err := autoOrient(vipImage); err != nil {
+				log.Context(ctx).Errorf("vips auto rotate error: %v", err)
 				return nil, err
 			}
-			originWidth := float64(vipImage.Width())
-			originHeight := float64(vipImage.Height())
+		}
+	}
 
-			if opt.w > 0 || opt.h > 0 || opt.l > 0 || opt.s > 0 {
-				switch opt.m {
-				case "pad":
-					fillWideAndHigh(originHeight, originWidth, opt)
-					opt.w, opt.h = fixedNum(opt.w, opt.h)
-					wScale := float64(opt.w) / originWidth
-					hScale := float64(opt.h) / originHeight
-					scale := math.Min(wScale, hScale)
-					if err = vipImage.ResizeWithVScale(scale, -1, vips.KernelLinear); err != nil {
-						log.Context(ctx).Errorf("vips resize with v scale error: %v", err)
-						return nil, err
-					}
+	encodeOpt := &EncodeOpt{
+		quality:  i.imageConf.GetQuality(),
+		metadata: i.imageConf.GetMetadata(),
+		avif:     i.imageConf.GetAvif(),
+		heif:     i.imageConf.GetHeif(),
+		jxl:      i.imageConf.GetJxl(),
+	}
 
-					if len(opt.color) > 0 {
-						var r, g, b int64
-						if _, err := fmt.Sscanf(opt.color, "%02x%02x%02x", &r, &g, &b); err != nil {
-							log.Context(ctx).Errorf("fmt sscanf error: %v", err)
-							return nil, err
-						}
-						backgroundColor := &vips.Color{
-							R: uint8(r),
-							G: uint8(g),
-							B: uint8(b),
-						}
-						if err = vipImage.EmbedBackground(
-							int((float64(opt.w)-float64(vipImage.Width()))/2),
-							int((float64(opt.h)-float64(vipImage.Height()))/2),
-							opt.w,
-							opt.h,
-							backgroundColor,
-						); err != nil {
-							log.Context(ctx).Errorf("vips embed background error: %v", err)
-							return nil, err
-						}
-					} else {
-						var extend vips.ExtendStrategy
-						if !vipImage.HasAlpha() {
-							extend = vips.ExtendWhite
-						} else {
-							extend = vips.ExtendBackground
-						}
-						if err = vipImage.Embed(
-							int((float64(opt.w)-float64(vipImage.Width()))/2),
-							int((float64(opt.h)-float64(vipImage.Height()))/2),
-							opt.w,
-							opt.h,
-							extend,
-						); err != nil {
-							log.Context(ctx).Errorf("vips embed error: %v", err)
-							return nil, err
-						}
-					}
-				case "fill":
-					fillWideAndHigh(originHeight, originWidth, opt)
-					opt.w, opt.h = fixedNum(opt.w, opt.h)
-					if limit(opt, vipImage) || opt.limit == 0 {
-						if err = vipImage.ThumbnailWithSize(opt.w, opt.h, vips.InterestingCentre, vips.SizeBoth); err != nil {
-							log.Context(ctx).Errorf("vips thumbnail with size error: %v", err)
-							return nil, err
-						}
-					}
-				case "fixed":
-					fillWideAndHigh(originHeight, originWidth, opt)
-					if opt.w > 0 && opt.h > 0 {
-						if opt.w > 0 && opt.w < vipImage.Width() && opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
-							if err = vipImage.ThumbnailWithSize(opt.w, opt.h, vips.InterestingAll, vips.SizeForce); err != nil {
-								log.Context(ctx).Errorf("vips thumbnail with size error: %v", err)
-								return nil, err
-							}
-						}
-					} else {
-						if opt.w > 0 && opt.w < vipImage.Width() || opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
-							wScale := float64(opt.w) / originWidth
-							hScale := float64(opt.h) / originHeight
-							wScale, hScale = fixedNum(wScale, hScale)
-							scale := math.Min(wScale, hScale)
-							if err = vipImage.ResizeWithVScale(scale, -1, vips.KernelLinear); err != nil {
-								log.Context(ctx).Errorf("vips resize with v scale error: %v", err)
-								return nil, err
-							}
-						}
-					}
-				case "mfit":
-					opt.l, opt.s = fixedNum(opt.l, opt.s)
-					fillWideAndHigh(originHeight, originWidth, opt)
-					if (opt.w > 0 || opt.h > 0) && opt.w < vipImage.Width() && opt.h < vipImage.Height() || opt.limit == 0 {
-						wScale := float64(opt.w) / originWidth
-						hScale := float64(opt.h) / originHeight
-						if err = vipImage.Resize(math.Max(wScale, hScale), vips.KernelLinear); err != nil {
-							log.Context(ctx).Errorf("vips resize error: %v", err)
-							return nil, err
-						}
-					}
-				default:
-					fillWideAndHigh(originHeight, originWidth, opt)
-					if opt.w > 0 && opt.w < vipImage.Width() || opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
-						if opt.w == 0 {
-							opt.w = vipImage.Width()
-						}
-						if opt.h == 0 {
-							opt.h = vipImage.Height()
-						}
-						wScale := float64(opt.w) / originWidth
-						hScale := float64(opt.h) / originHeight
-						if err = vipImage.Resize(math.Min(wScale, hScale), vips.KernelLinear); err != nil {
-							log.Context(ctx).Errorf("vips resize error: %v", err)
-							return nil, err
-						}
-					}
-				}
-			} else if opt.p > 0 {
-				if err := vipImage.Resize(float64(opt.p)/100, vips.KernelLinear); err != nil {
-					log.Context(ctx).Errorf("vips resize error: %v", err)
-					return nil, err
-				}
-			} else {
-				return nil, errors2.BadRequest("PARAM_ERROR", "Missing required param")
+	if err := i.processImage(ctx, vipImage, bucket, operations, encodeOpt); err != nil {
+		return nil, err
+	}
+
+	var targetFormat = vips.ImageTypes[vipImage.Format()]
+	//avif和heif共用heif的加载器，ImageTypes中两者都是heif
+	if vipImage.Format() == vips.ImageTypeAVIF {
+		targetFormat = "avif"
+	}
+	if formatOperation != nil {
+		targetFormat = outputFormats[formatOperation.Value().Raw]
+	}
+	if err := restorePages(vipImage, targetFormat, delay); err != nil {
+		log.Context(ctx).Errorf("vips restore pages error: %v", err)
+		return nil, err
+	}
+	resBuf, metadata, err := vipEncode(targetFormat, vipImage, encodeOpt)
+	if err != nil {
+		log.Context(ctx).Errorf("vips encode error: %v", err)
+		return nil, err
+	}
+	return nil, httpContext.Stream(http.StatusOK, GetMimeTypeByVipImageType(metadata.Format), bytes2.NewBuffer(resBuf))
+}
+
+// isAnimatedFormat reports whether the format can hold an animation that is kept through processing.
+func isAnimatedFormat(imageType vips.ImageType) bool {
+	return imageType == vips.ImageTypeGIF || imageType == vips.ImageTypeWEBP
+}
+
+// autoOrient turns the image upright following its exif orientation and drops the tag. Animations are
+// turned frame by frame, rotating the whole strip of frames at once would mix them up.
+func autoOrient(vipImage *vips.ImageRef) error {
+	if err := vipImage.ForEachPage(func(page *vips.ImageRef) error {
+		return page.AutoRotate()
+	}); err != nil {
+		return err
+	}
+	return vipImage.RemoveOrientation()
+}
+
+// restorePages prepares a processed animation for the encoder: the frame delays of the source are set
+// again, and only the first frame is kept when the target format is not animated.
+func restorePages(vipImage *vips.ImageRef, targetFormat string, delay []int) error {
+	if vipImage.Height() <= vipImage.PageHeight() {
+		return nil
+	}
+	if targetFormat != "gif" && targetFormat != "webp" {
+		return vipImage.ExtractPage(0)
+	}
+	if len(delay) == vipImage.Height()/vipImage.PageHeight() {
+		return vipImage.SetPageDelay(delay)
+	}
+	return nil
+}
+
+// processImage runs the operations in order on vipImage, the ones only affecting the encoder are
+// recorded into encodeOpt. bucket is where watermark image_ objects are looked up.
+func (i Image) processImage(ctx context.Context, vipImage *vips.ImageRef, bucket string, operations []process.Operation, encodeOpt *EncodeOpt) error {
+	for _, op := range operations {
+		switch op.Name {
+		case "resize":
+			//P_按主图比例缩放，只在水印图的处理链中有意义，见processWatermarkImage
+			if op.Has("P") {
+				return errors2.BadRequest("InvalidArgument", "Resize P is only supported in the process of a watermark image.")
 			}
-		case "watermark":
-			watermarkOpt, err := parseWatermarkOpt(ctx, op.opt)
+			opt, err := parseResizeOpt(op)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			params := vips.Watermark{
-				Text:        watermarkOpt.text,
-				Opacity:     float32(watermarkOpt.t) / float32(100),
-				Width:       100,
-				Rotate:      watermarkOpt.rotate - 360,
-				DPI:         72,
-				Margin:      20,
-				Font:        fmt.Sprintf("fangzhengheiti  %d", watermarkOpt.size),
-				NoReplicate: watermarkOpt.fill != 1,
-			}
-			var r, g, b int64
-			if _, err := fmt.Sscanf(watermarkOpt.color, "%02x%02x%02x", &r, &g, &b); err != nil {
-				log.Context(ctx).Error(err)
-				return nil, err
+			//动图逐帧缩放，每一帧使用同一份参数
+			if err := vipImage.ForEachPage(func(page *vips.ImageRef) error {
+				pageOpt := *opt
+				return resizeImage(ctx, page, &pageOpt)
+			}); err != nil {
+				return err
 			}
-			backgroundColor := vips.Color{
-				R: uint8(r),
-				G: uint8(g),
-				B: uint8(b),
+		case "watermark":
+			watermarkOpt, err := parseWatermarkOpt(op)
+			if err != nil {
+				return err
 			}
-			params.Background = backgroundColor
-			if err := vipImage.AddAlpha(); err != nil {
-				log.Context(ctx).Error(err)
-				return nil, err
+			watermarkImage, err := i.watermarkImage(ctx, watermarkOpt, bucket, vipImage.Width(), vipImage.PageHeight())
+			if err != nil {
+				return err
 			}
-			if err := vipImage.WaterMark(&params); err != nil {
-				log.Context(ctx).Error(err)
-				return nil, err
+			err = placeWatermark(vipImage, watermarkImage, watermarkOpt)
+			watermarkImage.Close()
+			if err != nil {
+				log.Context(ctx).Errorf("vips place watermark error: %v", err)
+				return err
 			}
 		case "blur":
-			sigma, minAmpl, err := parseBlurOpt(ctx, op.opt)
+			sigma, minAmpl, err := parseBlurOpt(op)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if err := vipImage.GaussianBlur(sigma, minAmpl); err != nil {
+			if err := vipImage.ForEachPage(func(page *vips.ImageRef) error {
+				return page.GaussianBlur(sigma, minAmpl)
+			}); err != nil {
 				log.Context(ctx).Errorf("vips gaussian blur error: %v", err)
-				return nil, err
+				return err
+			}
+		case "crop":
+			cropOpt := parseCropOpt(op)
+			left, top, width, height, err := cropArea(vipImage.Width(), vipImage.PageHeight(), cropOpt)
+			if err != nil {
+				return err
+			}
+			if err := vipImage.ExtractArea(left, top, width, height); err != nil {
+				log.Context(ctx).Errorf("vips extract area error: %v", err)
+				return err
+			}
+		case "quality":
+			qualityOpt, err := parseQualityOpt(op)
+			if err != nil {
+				return err
+			}
+			encodeOpt.requestQuality = qualityOpt.resolve(vipImage.JpegQuality())
+		case "circle":
+			r := op.Int("r", 0)
+			width, height := vipImage.Width(), vipImage.PageHeight()
+			maxRadius := int(math.Min(float64(width), float64(height)) / 2)
+			if r == 0 || r > maxRadius {
+				r = maxRadius
+			}
+			if err := vipImage.ExtractArea(width/2-r, height/2-r, 2*r, 2*r); err != nil {
+				log.Context(ctx).Errorf("vips extract area error: %v", err)
+				return err
+			}
+			circle := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"><circle cx="%d" cy="%d" r="%d" fill="#fff"/></svg>`, 2*r, 2*r, r, r, r)
+			if err := applyAlphaMask(vipImage, circle); err != nil {
+				log.Context(ctx).Errorf("vips apply circle mask error: %v", err)
+				return err
+			}
+		case "rounded-corners":
+			r := op.Int("r", 0)
+			width, height := vipImage.Width(), vipImage.PageHeight()
+			if maxRadius := int(math.Min(float64(width), float64(height)) / 2); r > maxRadius {
+				r = maxRadius
+			}
+			roundedRect := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d"><rect width="%d" height="%d" rx="%d" ry="%d" fill="#fff"/></svg>`, width, height, width, height, r, r)
+			if err := applyAlphaMask(vipImage, roundedRect); err != nil {
+				log.Context(ctx).Errorf("vips apply rounded corners mask error: %v", err)
+				return err
+			}
+		case "indexcrop":
+			indexCropOpt, err := parseIndexCropOpt(op)
+			if err != nil {
+				return err
+			}
+			left, top, width, height, ok := indexCropArea(vipImage.Width(), vipImage.PageHeight(), indexCropOpt)
+			//索引超出范围时，按照OSS的处理方式返回原图
+			if !ok {
+				continue
+			}
+			if err := vipImage.ExtractArea(left, top, width, height); err != nil {
+				log.Context(ctx).Errorf("vips extract area error: %v", err)
+				return err
+			}
+		case "interlace":
+			interlace := op.Value().Raw == "1"
+			encodeOpt.interlace = &interlace
+		case "rotate":
+			angle := op.Value().Int % 360
+			if err := vipImage.ForEachPage(func(page *vips.ImageRef) error {
+				return rotate(page, angle)
+			}); err != nil {
+				log.Context(ctx).Errorf("vips rotate error: %v", err)
+				return err
+			}
+		case "metadata":
+			encodeOpt.metadata = op.Value().Raw
+		case "bright":
+			bright := op.Value().Int
+			if err := linearTone(vipImage, 1, float64(bright)/100*bandMax(vipImage.BandFormat())); err != nil {
+				log.Context(ctx).Errorf("vips bright error: %v", err)
+				return err
+			}
+		case "contrast":
+			contrast := op.Value().Int
+			//对比度系数与常见的修图软件一致，-100时为纯灰色；系数按8位图推导，中点随位深放大
+			c := float64(contrast) * 255 / 100
+			factor := 259 * (c + 255) / (255 * (259 - c))
+			middle := 128 * bandMax(vipImage.BandFormat()) / 255
+			if err := linearTone(vipImage, factor, middle*(1-factor)); err != nil {
+				log.Context(ctx).Errorf("vips contrast error: %v", err)
+				return err
+			}
+		case "sharpen":
+			sharpen := op.Value().Int
+			//OSS没有公开锐化算法，这里把取值线性映射为vips_sharpen的m2（边缘处的锐化斜率）：
+			//OSS推荐的100对应m2=2，50~399对应1~8；sigma=1为常用的USM半径，x1取vips默认的2。
+			//该映射只保证锐化程度随取值单调增加，与OSS的输出近似而非逐像素一致，TestOSSReference按testdata/oss中OSS的输出校验
+			if err := vipImage.ForEachPage(func(page *vips.ImageRef) error {
+				return page.Sharpen(1, 2, float64(sharpen)/50)
+			}); err != nil {
+				log.Context(ctx).Errorf("vips sharpen error: %v", err)
+				return err
 			}
 		default:
-			return nil, errors2.BadRequest("unknown opt", "unknown opt")
+			return errors2.BadRequest("unknown opt", "unknown opt")
 		}
 	}
+	return nil
+}
 
-	var targetFormat = vips.ImageTypes[vipImage.Format()]
-	if formatOperation != nil {
-		targetFormat, err = parseFormatOpt(formatOperation.opt)
+// averageHue shrinks the image into a single pixel, which is the mean of all pixels,
+// and formats it the way OSS does, e.g. 0x5c783b.
+func averageHue(vipImage *vips.ImageRef) (string, error) {
+	if err := vipImage.ToColorSpace(vips.InterpretationSRGB); err != nil {
+		return "", err
+	}
+	if err := vipImage.Shrink(float64(vipImage.Width()), float64(vipImage.Height())); err != nil {
+		return "", err
+	}
+	point, err := vipImage.GetPoint(0, 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("0x%02x%02x%02x", uint8(math.Round(point[0])), uint8(math.Round(point[1])), uint8(math.Round(point[2]))), nil
+}
+
+type WatermarkOpt struct {
+	color       string
+	fill        int
+	rotate      int
+	t           int
+	text        string
+	size        int
+	image       string
+	P           int
+	g           string
+	x           int
+	y           int
+	voffset     int
+	padx        int
+	pady        int
+	order       int
+	align       int
+	interval    int
+	font        string
+	shadow      int
+	stroke      int
+	strokeColor string
+}
+
+func parseWatermarkOpt(op process.Operation) (*WatermarkOpt, error) {
+	var opt = WatermarkOpt{
+		color:       op.String("color", "000000"),
+		fill:        op.Int("fill", 0),
+		rotate:      op.Int("rotate", 0),
+		t:           op.Int("t", 100),
+		text:        op.String("text", ""),
+		size:        op.Int("size", 40),
+		image:       op.String("image", ""),
+		P:           op.Int("P", 0),
+		g:           op.String("g", "se"),
+		x:           op.Int("x", 10),
+		y:           op.Int("y", 10),
+		voffset:     op.Int("voffset", 0),
+		padx:        op.Int("padx", 0),
+		pady:        op.Int("pady", 0),
+		order:       op.Int("order", 0),
+		align:       op.Int("align", 0),
+		interval:    op.Int("interval", 0),
+		font:        op.String("type", ""),
+		shadow:      op.Int("shadow", 0),
+		stroke:      op.Int("stroke", 0),
+		strokeColor: op.String("strokecolor", "000000"),
+	}
+	if len(opt.text) == 0 && len(opt.image) == 0 {
+		return nil, errors2.BadRequest("PARAM_ERROR", "Missing required param: text or image")
+	}
+	return &opt, nil
+}
+
+// watermarkImage builds the overlay of a watermark operation, which is the image watermark, the text
+// watermark, or both of them laid out side by side when image_ and text_ are given together.
+func (i Image) watermarkImage(ctx context.Context, opt *WatermarkOpt, bucket string, width, height int) (*vips.ImageRef, error) {
+	if len(opt.image) == 0 {
+		return i.textWatermarkImage(ctx, opt)
+	}
+	imageWatermark, err := i.loadWatermarkImage(ctx, opt, bucket, width, height)
+	if err != nil || len(opt.text) == 0 {
+		return imageWatermark, err
+	}
+	textWatermark, err := i.textWatermarkImage(ctx, opt)
+	if err != nil {
+		imageWatermark.Close()
+		return nil, err
+	}
+	defer textWatermark.Close()
+	if err := combineWatermarks(imageWatermark, textWatermark, opt); err != nil {
+		imageWatermark.Close()
+		log.Context(ctx).Errorf("vips combine watermarks error: %v", err)
+		return nil, err
+	}
+	return imageWatermark, nil
+}
+
+// combineWatermarks puts the text next to the image watermark, the result is kept in imageWatermark.
+// order_ 0 puts the image first and 1 the text, align_ 0, 1 and 2 align them to the top, middle and
+// bottom, and interval_ is the gap between them.
+func combineWatermarks(imageWatermark *vips.ImageRef, textWatermark *vips.ImageRef, opt *WatermarkOpt) error {
+	imageWidth, imageHeight := imageWatermark.Width(), imageWatermark.Height()
+	textWidth, textHeight := textWatermark.Width(), textWatermark.Height()
+	width := imageWidth + opt.interval + textWidth
+	height := int(math.Max(float64(imageHeight), float64(textHeight)))
+
+	imageLeft, textLeft := 0, imageWidth+opt.interval
+	if opt.order == 1 {
+		imageLeft, textLeft = textWidth+opt.interval, 0
+	}
+	alignTop := func(h int) int {
+		switch opt.align {
+		case 1:
+			return (height - h) / 2
+		case 2:
+			return height - h
+		default:
+			return 0
+		}
+	}
+
+	if err := imageWatermark.Embed(imageLeft, alignTop(imageHeight), width, height, vips.ExtendBackground); err != nil {
+		return err
+	}
+	return imageWatermark.Composite(textWatermark, vips.BlendModeOver, textLeft, alignTop(textHeight))
+}
+
+// textWatermarkImage renders text_ with its font, size, color, rotation and transparency.
+func (i Image) textWatermarkImage(ctx context.Context, opt *WatermarkOpt) (*vips.ImageRef, error) {
+	color, alpha, err := parseWatermarkColor(opt.color)
+	if err != nil {
+		log.Context(ctx).Error(err)
+		return nil, errors2.BadRequest("InvalidArgument", fmt.Sprintf("Invalid watermark color: %s", opt.color))
+	}
+	strokeColor, _, err := parseWatermarkColor(opt.strokeColor)
+	if err != nil {
+		log.Context(ctx).Error(err)
+		return nil, errors2.BadRequest("InvalidArgument", fmt.Sprintf("Invalid watermark stroke color: %s", opt.strokeColor))
+	}
+	family, err := i.watermarkFont(opt)
+	if err != nil {
+		return nil, err
+	}
+	//vips_rotate按顺时针旋转，与OSS的rotate_一致，和最初rotate-360的写法是同一个角度
+	textImage, err := vips.NewTextImage(&vips.TextParams{
+		Text:          opt.text,
+		Font:          fmt.Sprintf("%s %d", family, opt.size),
+		DPI:           72,
+		Rotate:        opt.rotate,
+		Opacity:       float32(opt.t) / float32(100) * alpha,
+		Color:         color,
+		ShadowOpacity: float32(opt.shadow) / float32(100),
+		ShadowOffset:  int(math.Max(1, math.Round(float64(opt.size)/20))),
+		StrokeWidth:   opt.stroke,
+		StrokeColor:   strokeColor,
+	})
+	if err != nil {
+		log.Context(ctx).Errorf("vips text image error: %v", err)
+		return nil, err
+	}
+	return textImage, nil
+}
+
+// parseWatermarkColor parses RRGGBB, or RRGGBBAA where the alpha scales the opacity of the text.
+func parseWatermarkColor(s string) (vips.Color, float32, error) {
+	var r, g, b, a uint8
+	switch len(s) {
+	case 6:
+		a = 0xff
+		if _, err := fmt.Sscanf(s, "%02x%02x%02x", &r, &g, &b); err != nil {
+			return vips.Color{}, 0, err
+		}
+	case 8:
+		if _, err := fmt.Sscanf(s, "%02x%02x%02x%02x", &r, &g, &b, &a); err != nil {
+			return vips.Color{}, 0, err
+		}
+	default:
+		return vips.Color{}, 0, fmt.Errorf("invalid color length: %s", s)
+	}
+	return vips.Color{R: r, G: g, B: b}, float32(a) / float32(0xff), nil
+}
+
+// defaultWatermarkFont is the font OSS uses when type_ is not given.
+const defaultWatermarkFont = "wqy-zenhei"
+
+// fallbackWatermarkFont is the pango family used without type_ when defaultWatermarkFont is not available,
+// fontconfig picks a system font for it.
+const fallbackWatermarkFont = "Sans"
+
+// watermarkFont resolves type_, an OSS font id such as fangzhengshusong, to a font family: first through
+// vip.fonts, then against the fonts registered from the font dir, where the id is the font file name.
+// Ids resolving to nothing are rejected rather than rendered with whatever font fontconfig falls back to.
+// Without type_ the OSS default is resolved the same way, and a system font is used when it is missing.
+func (i Image) watermarkFont(opt *WatermarkOpt) (string, error) {
+	if len(opt.font) == 0 {
+		if family, ok := i.fontFamily(defaultWatermarkFont); ok {
+			return family, nil
+		}
+		return fallbackWatermarkFont, nil
+	}
+	if family, ok := i.fontFamily(opt.font); ok {
+		return family, nil
+	}
+	return "", errors2.BadRequest("InvalidArgument", fmt.Sprintf("Unsupported watermark font: %s", opt.font))
+}
+
+func (i Image) fontFamily(font string) (string, bool) {
+	if family, ok := i.fonts[font]; ok {
+		return family, true
+	}
+	return vips.FontFamily(font)
+}
+
+// placeWatermark composites the watermark at the position given by g_, x_, y_ and voffset_, or tiles it
+// over the whole image with fill_1, using padx_ and pady_ as the gaps between the tiles like OSS does.
+func placeWatermark(vipImage *vips.ImageRef, watermarkImage *vips.ImageRef, opt *WatermarkOpt) error {
+	width, height := vipImage.Width(), vipImage.PageHeight()
+	var left, top int
+	if opt.fill == 1 {
+		if err := watermarkImage.Embed(0, 0, watermarkImage.Width()+opt.padx, watermarkImage.Height()+opt.pady, vips.ExtendBackground); err != nil {
+			return err
+		}
+		if err := watermarkImage.Replicate(width/watermarkImage.Width()+1, height/watermarkImage.Height()+1); err != nil {
+			return err
+		}
+		if err := watermarkImage.ExtractArea(0, 0, width, height); err != nil {
+			return err
+		}
+	} else {
+		left, top = watermarkOffset(opt, width, height, watermarkImage.Width(), watermarkImage.Height())
+	}
+	//动图的每一帧叠加同一个水印
+	return vipImage.ForEachPage(func(page *vips.ImageRef) error {
+		return page.Composite(watermarkImage, vips.BlendModeOver, left, top)
+	})
+}
+
+// watermarkOffset follows OSS: x_ and y_ are the margins to the edges the watermark is attached to, x_ is
+// ignored on the centre column, and on the centre row y_ is replaced by voffset_, where positive moves up.
+func watermarkOffset(opt *WatermarkOpt, width, height, watermarkWidth, watermarkHeight int) (left, top int) {
+	x, y := opt.x, opt.y
+	switch opt.g {
+	case "north", "center", "south":
+		x = 0
+	}
+	switch opt.g {
+	case "west", "center", "east":
+		y = -opt.voffset
+	}
+	return gravityOffset(opt.g, width, height, watermarkWidth, watermarkHeight, x, y)
+}
+
+// loadWatermarkImage loads the watermark object from bucket and runs its own x-oss-process chain, see
+// processWatermarkImage, then applies the transparency t_.
+func (i Image) loadWatermarkImage(ctx context.Context, opt *WatermarkOpt, bucket string, width, height int) (*vips.ImageRef, error) {
+	key, processOpt, _ := strings.Cut(opt.image, "?x-oss-process=")
+	reader, err := i.storage.Get(ctx, bucket, key)
+	if err != nil {
+		log.Context(ctx).Errorf("storage get watermark %s/%s error: %v", bucket, key, err)
+		return nil, err
+	}
+	defer reader.Close()
+	buf, err := io.ReadAll(reader)
+	if err != nil {
+		log.Context(ctx).Errorf("read watermark %s error: %v", key, err)
+		return nil, err
+	}
+	watermarkImage, err := vips.LoadImageFromBuffer(buf, vips.NewImportParams())
+	if err != nil {
+		log.Context(ctx).Errorf("vips load watermark %s error: %v", key, err)
+		return nil, errors2.BadRequest("InvalidArgument", "The watermark image is not a valid image.")
+	}
+
+	if processOpt, err = i.styles.Expand(processOpt); err != nil {
+		watermarkImage.Close()
+		return nil, err
+	}
+	if len(processOpt) > 0 {
+		operations, err := process.Parse(processOpt)
 		if err != nil {
-			log.Context(ctx).Errorf("parse format opt error: %v", err)
+			watermarkImage.Close()
+			return nil, err
+		}
+		if err := i.processWatermarkImage(ctx, watermarkImage, bucket, operations, width, height); err != nil {
+			watermarkImage.Close()
 			return nil, err
 		}
 	}
-	resBuf, metadata, err := vipEncode(targetFormat, vipImage, i.imageConf.GetQuality())
-	if err != nil {
-		log.Context(ctx).Errorf("vips encode error: %v", err)
+
+	//watermark的P_与处理链中的resize,P_含义相同，保留作为别名
+	if opt.P > 0 {
+		if err := scaleWatermark(watermarkImage, opt.P, width, height); err != nil {
+			watermarkImage.Close()
+			log.Context(ctx).Errorf("vips resize watermark error: %v", err)
+			return nil, err
+		}
+	}
+	if err := applyOpacity(watermarkImage, float64(opt.t)/100); err != nil {
+		watermarkImage.Close()
+		log.Context(ctx).Errorf("vips watermark opacity error: %v", err)
 		return nil, err
 	}
-	return nil, httpContext.Stream(http.StatusOK, GetMimeTypeByVipImageType(metadata.Format), bytes2.NewBuffer(resBuf))
+	return watermarkImage, nil
 }
 
-type WatermarkOpt struct {
-	color  string
-	fill   int
-	rotate int
-	t      int
-	text   string
-	size   int
-}
-
-func parseWatermarkOpt(ctx context.Context, watermarkOpt []string) (*WatermarkOpt, error) {
-	var opt = WatermarkOpt{fill: 0, rotate: 0, t: 100, color: "000000", size: 40}
-	for _, o := range watermarkOpt {
-		if strings.HasPrefix(o, "text_") {
-			s := strings.ReplaceAll(o, "text_", "")
-			buf, err := base64.RawStdEncoding.DecodeString(s)
-			if err != nil {
-				log.Context(ctx).Error(err)
-				return nil, err
+// processWatermarkImage runs the x-oss-process chain of a watermark image. resize,P_ scales the watermark
+// to a percentage of the main image of width x height like OSS does, format is ignored since the watermark
+// is not encoded on its own, and operations answering json or adding another watermark are rejected.
+func (i Image) processWatermarkImage(ctx context.Context, watermarkImage *vips.ImageRef, bucket string, operations []process.Operation, width, height int) error {
+	for _, op := range operations {
+		switch {
+		case op.Name == "watermark" || op.Name == "info" || op.Name == "average-hue":
+			return errors2.BadRequest("InvalidArgument", fmt.Sprintf("Watermark image can not contain %s.", op.Name))
+		case op.Name == "format":
+			continue
+		case op.Name == "auto-orient":
+			if op.Value().Raw == "1" {
+				if err := autoOrient(watermarkImage); err != nil {
+					log.Context(ctx).Errorf("vips auto rotate watermark error: %v", err)
+					return err
+				}
+			}
+		case op.Name == "resize" && op.Has("P"):
+			if err := scaleWatermark(watermarkImage, op.Int("P", 0), width, height); err != nil {
+				log.Context(ctx).Errorf("vips resize watermark error: %v", err)
+				return err
+			}
+		default:
+			if err := i.processImage(ctx, watermarkImage, bucket, []process.Operation{op}, &EncodeOpt{}); err != nil {
+				return err
 			}
-			opt.text = string(buf)
-		} else if strings.HasPrefix(o, "fill_") {
-			s := strings.ReplaceAll(o, "fill_", "")
-			opt.fill, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "size_") {
-			s := strings.ReplaceAll(o, "size_", "")
-			opt.size, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "rotate_") {
-			s := strings.ReplaceAll(o, "rotate_", "")
-			opt.rotate, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "t_") {
-			s := strings.ReplaceAll(o, "t_", "")
-			opt.t, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "color_") {
-			opt.color = strings.ReplaceAll(o, "color_", "")
 		}
 	}
-	if len(opt.text) == 0 {
-		return nil, errors2.BadRequest("PARAM_ERROR", "Missing required param: text")
+	return nil
+}
+
+// scaleWatermark resizes the watermark to fit p percent of the main image of width x height, keeping
+// its aspect ratio.
+func scaleWatermark(watermarkImage *vips.ImageRef, p int, width, height int) error {
+	scale := math.Min(float64(width*p)/100/float64(watermarkImage.Width()), float64(height*p)/100/float64(watermarkImage.Height()))
+	return watermarkImage.Resize(scale, vips.KernelLinear)
+}
+
+// applyOpacity scales the alpha band of vipImage, an opaque alpha band is added first when missing.
+func applyOpacity(vipImage *vips.ImageRef, opacity float64) error {
+	if err := vipImage.AddAlpha(); err != nil {
+		return err
 	}
-	return &opt, nil
+	if opacity >= 1 {
+		return nil
+	}
+	format := vipImage.BandFormat()
+	bands := vipImage.Bands()
+	multiplications := make([]float64, bands)
+	for i := range multiplications {
+		multiplications[i] = 1
+	}
+	multiplications[bands-1] = opacity
+	if err := vipImage.Linear(multiplications, make([]float64, bands)); err != nil {
+		return err
+	}
+	return vipImage.Cast(format)
 }
 
 type ResizeOpt struct {
@@ -366,32 +841,16 @@ type ResizeOpt struct {
 	p     int
 }
 
-func parseResizeOpt(resizeOpt []string) (*ResizeOpt, error) {
-	var opt = ResizeOpt{limit: 1}
-	for _, o := range resizeOpt {
-		if strings.HasPrefix(o, "w_") {
-			s := strings.ReplaceAll(o, "w_", "")
-			opt.w, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "h_") {
-			s := strings.ReplaceAll(o, "h_", "")
-			opt.h, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "limit_") {
-			s := strings.ReplaceAll(o, "limit_", "")
-			opt.limit, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "m_") {
-			opt.m = strings.ReplaceAll(o, "m_", "")
-		} else if strings.HasPrefix(o, "color_") {
-			opt.color = strings.ReplaceAll(o, "color_", "")
-		} else if strings.HasPrefix(o, "l_") {
-			s := strings.ReplaceAll(o, "l_", "")
-			opt.l, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "s_") {
-			s := strings.ReplaceAll(o, "s_", "")
-			opt.s, _ = strconv.Atoi(s)
-		} else if strings.HasPrefix(o, "p_") {
-			s := strings.ReplaceAll(o, "p_", "")
-			opt.p, _ = strconv.Atoi(s)
-		}
+func parseResizeOpt(op process.Operation) (*ResizeOpt, error) {
+	var opt = ResizeOpt{
+		w:     op.Int("w", 0),
+		h:     op.Int("h", 0),
+		limit: op.Int("limit", 1),
+		m:     op.String("m", ""),
+		color: op.String("color", ""),
+		l:     op.Int("l", 0),
+		s:     op.Int("s", 0),
+		p:     op.Int("p", 0),
 	}
 	//如果图片处理URL中同时指定按宽高缩放和等比缩放参数，则只执行指定宽高缩放
 	if opt.p > 0 && (opt.w > 0 || opt.h > 0) {
@@ -402,66 +861,496 @@ func parseResizeOpt(resizeOpt []string) (*ResizeOpt, error) {
 		opt.l = 0
 		opt.s = 0
 	}
-	if opt.w == 0 && opt.h == 0 && opt.l == 0 && opt.s == 0 {
-		return nil, errors2.BadRequest("InvalidArgument", "Width and Height can not both be 0.")
+	if opt.w == 0 && opt.h == 0 && opt.l == 0 && opt.s == 0 && opt.p == 0 {
+		return nil, errors2.BadRequest("MissingArgument", "Missing required param: w, h, l, s or p")
 	}
 	return &opt, nil
 }
 
-func parseFormatOpt(formatOpt []string) (string, error) {
-	targetFormat := formatOpt[0]
-	if len(targetFormat) == 0 {
-		return "", errors2.BadRequest("PARAM_ERROR", "Missing required param: format")
+// outputFormats maps the values accepted by the format operation to the names used by vipEncode.
+var outputFormats = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"png":  "png",
+	"webp": "webp",
+	"tiff": "tiff",
+	"gif":  "gif",
+	"avif": "avif",
+	"heic": "heif",
+	"heif": "heif",
+	"jxl":  "jxl",
+	"bmp":  "bmp",
+	"jp2":  "jp2k",
+}
+
+func parseBlurOpt(op process.Operation) (sigma float64, minAmpl float64, err error) {
+	sigma = op.Float("s", 0)
+	radius := op.Float("r", 0)
+	if sigma == 0 || radius == 0 {
+		return 0, 0, errors2.BadRequest("InvalidArgument", "Blur r and s must be greater than 0.")
+	}
+	minAmpl = 1 - (math.Pow(radius/float64(50), 2) / float64(2))
+	return
+}
+
+// isMetadataMode reports whether mode is one of the metadata operation values, empty means all.
+func isMetadataMode(mode string) bool {
+	switch mode {
+	case "", "all", "icc", "privacy":
+		return true
 	}
-	return targetFormat, nil
+	return false
 }
 
-func parseBlurOpt(ctx context.Context, opt []string) (sigma float64, minAmpl float64, err error) {
-	var radius float64
-	for _, o := range opt {
-		if strings.HasPrefix(o, "s_") {
-			s := strings.ReplaceAll(o, "s_", "")
-			sigma, err = strconv.ParseFloat(s, 64)
-			if err != nil {
-				log.Context(ctx).Error(err)
-				return 0, 0, err
+// stripMetadata removes metadata before encoding: all keeps everything, icc keeps only the icc profile,
+// orientation and the frame layout and timing of animations, privacy removes the gps tags, every serial
+// number and the xmp packet, which may repeat them.
+func stripMetadata(vipImage *vips.ImageRef, mode string) error {
+	switch mode {
+	case "icc":
+		return vipImage.RemoveFields(func(field string) bool {
+			switch field {
+			case "icc-profile-data", "orientation", "n-pages", "page-height", "delay", "loop", "gif-delay", "gif-loop":
+				return false
 			}
-		} else if strings.HasPrefix(o, "r_") {
-			s := strings.ReplaceAll(o, "r_", "")
-			radius, err = strconv.ParseFloat(s, 64)
-			if err != nil {
-				log.Context(ctx).Error(err)
-				return 0, 0, err
+			return true
+		})
+	case "privacy":
+		return vipImage.RemoveFields(func(field string) bool {
+			return strings.HasPrefix(field, "exif-ifd3-") || strings.HasSuffix(field, "SerialNumber") || field == "xmp-data"
+		})
+	}
+	return nil
+}
+
+// linearTone computes a*pixel+b on the colour bands only, the alpha band is kept as is and the result
+// is clipped back into the original band format. b is in the units of the band format, see bandMax.
+func linearTone(vipImage *vips.ImageRef, a, b float64) error {
+	format := vipImage.BandFormat()
+	bands := vipImage.Bands()
+	multiplications := make([]float64, bands)
+	additions := make([]float64, bands)
+	for i := range multiplications {
+		multiplications[i] = a
+		additions[i] = b
+	}
+	if vipImage.HasAlpha() {
+		multiplications[bands-1] = 1
+		additions[bands-1] = 0
+	}
+	if err := vipImage.Linear(multiplications, additions); err != nil {
+		return err
+	}
+	return vipImage.Cast(format)
+}
+
+// bandMax is the value of full intensity for the band format, e.g. 255 for 8 bit and 65535 for 16 bit
+// images. Float images follow the vips convention of sRGB in 0-255.
+func bandMax(format vips.BandFormat) float64 {
+	switch format {
+	case vips.BandFormatChar:
+		return math.MaxInt8
+	case vips.BandFormatUshort:
+		return math.MaxUint16
+	case vips.BandFormatShort:
+		return math.MaxInt16
+	case vips.BandFormatUint:
+		return math.MaxUint32
+	case vips.BandFormatInt:
+		return math.MaxInt32
+	}
+	return math.MaxUint8
+}
+
+type QualityOpt struct {
+	q int
+	Q int
+}
+
+func parseQualityOpt(op process.Operation) (*QualityOpt, error) {
+	var opt = QualityOpt{q: op.Int("q", 0), Q: op.Int("Q", 0)}
+	if opt.q == 0 && opt.Q == 0 {
+		return nil, errors2.BadRequest("MissingArgument", "Missing required param: q or Q")
+	}
+	return &opt, nil
+}
+
+// resolve turns the operation into an encoder quality. Q is absolute, while q is relative to the
+// estimated quality of a jpeg source (sourceQuality, 0 if unknown) and can never exceed it.
+func (o *QualityOpt) resolve(sourceQuality int) int32 {
+	if o.Q > 0 {
+		return int32(o.Q)
+	}
+	if sourceQuality <= 0 {
+		sourceQuality = 100
+	}
+	return int32(math.Max(1, math.Round(float64(sourceQuality*o.q)/100)))
+}
+
+type IndexCropOpt struct {
+	x int
+	y int
+	i int
+}
+
+func parseIndexCropOpt(op process.Operation) (*IndexCropOpt, error) {
+	var opt = IndexCropOpt{x: op.Int("x", 0), y: op.Int("y", 0), i: op.Int("i", 0)}
+	if (opt.x > 0) == (opt.y > 0) {
+		return nil, errors2.BadRequest("InvalidArgument", "Exactly one of x and y must be set.")
+	}
+	return &opt, nil
+}
+
+// indexCropArea cuts the image into strips x wide, or y high, and returns the strip at index i. The last
+// strip is narrower when the size is not a multiple, and ok is false when i is past the last strip.
+func indexCropArea(imageWidth, imageHeight int, opt *IndexCropOpt) (left, top, width, height int, ok bool) {
+	width, height = imageWidth, imageHeight
+	if opt.x > 0 {
+		left = opt.x * opt.i
+		width = int(math.Min(float64(opt.x), float64(imageWidth-left)))
+	} else {
+		top = opt.y * opt.i
+		height = int(math.Min(float64(opt.y), float64(imageHeight-top)))
+	}
+	return left, top, width, height, width > 0 && height > 0
+}
+
+// resizeImage scales a single page following the m_ mode of opt. opt is updated while resolving
+// the target size.
+func resizeImage(ctx context.Context, vipImage *vips.ImageRef, opt *ResizeOpt) error {
+	var err error
+	originWidth := float64(vipImage.Width())
+	originHeight := float64(vipImage.Height())
+
+	if opt.w > 0 || opt.h > 0 || opt.l > 0 || opt.s > 0 {
+		switch opt.m {
+		case "pad":
+			fillWideAndHigh(originHeight, originWidth, opt)
+			opt.w, opt.h = fixedNum(opt.w, opt.h)
+			wScale := float64(opt.w) / originWidth
+			hScale := float64(opt.h) / originHeight
+			scale := math.Min(wScale, hScale)
+			if err = vipImage.ResizeWithVScale(scale, -1, vips.KernelLinear); err != nil {
+				log.Context(ctx).Errorf("vips resize with v scale error: %v", err)
+				return err
+			}
+
+			if len(opt.color) > 0 {
+				var r, g, b int64
+				if _, err := fmt.Sscanf(opt.color, "%02x%02x%02x", &r, &g, &b); err != nil {
+					log.Context(ctx).Errorf("fmt sscanf error: %v", err)
+					return err
+				}
+				backgroundColor := &vips.Color{
+					R: uint8(r),
+					G: uint8(g),
+					B: uint8(b),
+				}
+				if err = vipImage.EmbedBackground(
+					int((float64(opt.w)-float64(vipImage.Width()))/2),
+					int((float64(opt.h)-float64(vipImage.Height()))/2),
+					opt.w,
+					opt.h,
+					backgroundColor,
+				); err != nil {
+					log.Context(ctx).Errorf("vips embed background error: %v", err)
+					return err
+				}
+			} else {
+				var extend vips.ExtendStrategy
+				if !vipImage.HasAlpha() {
+					extend = vips.ExtendWhite
+				} else {
+					extend = vips.ExtendBackground
+				}
+				if err = vipImage.Embed(
+					int((float64(opt.w)-float64(vipImage.Width()))/2),
+					int((float64(opt.h)-float64(vipImage.Height()))/2),
+					opt.w,
+					opt.h,
+					extend,
+				); err != nil {
+					log.Context(ctx).Errorf("vips embed error: %v", err)
+					return err
+				}
+			}
+		case "fill":
+			fillWideAndHigh(originHeight, originWidth, opt)
+			opt.w, opt.h = fixedNum(opt.w, opt.h)
+			if limit(opt, vipImage) || opt.limit == 0 {
+				if err = vipImage.ThumbnailWithSize(opt.w, opt.h, vips.InterestingCentre, vips.SizeBoth); err != nil {
+					log.Context(ctx).Errorf("vips thumbnail with size error: %v", err)
+					return err
+				}
+			}
+		case "fixed":
+			fillWideAndHigh(originHeight, originWidth, opt)
+			if opt.w > 0 && opt.h > 0 {
+				if opt.w > 0 && opt.w < vipImage.Width() && opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
+					if err = vipImage.ThumbnailWithSize(opt.w, opt.h, vips.InterestingAll, vips.SizeForce); err != nil {
+						log.Context(ctx).Errorf("vips thumbnail with size error: %v", err)
+						return err
+					}
+				}
+			} else {
+				if opt.w > 0 && opt.w < vipImage.Width() || opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
+					wScale := float64(opt.w) / originWidth
+					hScale := float64(opt.h) / originHeight
+					wScale, hScale = fixedNum(wScale, hScale)
+					scale := math.Min(wScale, hScale)
+					if err = vipImage.ResizeWithVScale(scale, -1, vips.KernelLinear); err != nil {
+						log.Context(ctx).Errorf("vips resize with v scale error: %v", err)
+						return err
+					}
+				}
+			}
+		case "mfit":
+			opt.l, opt.s = fixedNum(opt.l, opt.s)
+			fillWideAndHigh(originHeight, originWidth, opt)
+			if (opt.w > 0 || opt.h > 0) && opt.w < vipImage.Width() && opt.h < vipImage.Height() || opt.limit == 0 {
+				wScale := float64(opt.w) / originWidth
+				hScale := float64(opt.h) / originHeight
+				if err = vipImage.Resize(math.Max(wScale, hScale), vips.KernelLinear); err != nil {
+					log.Context(ctx).Errorf("vips resize error: %v", err)
+					return err
+				}
 			}
+		default:
+			fillWideAndHigh(originHeight, originWidth, opt)
+			if opt.w > 0 && opt.w < vipImage.Width() || opt.h > 0 && opt.h < vipImage.Height() || opt.limit == 0 {
+				if opt.w == 0 {
+					opt.w = vipImage.Width()
+				}
+				if opt.h == 0 {
+					opt.h = vipImage.Height()
+				}
+				wScale := float64(opt.w) / originWidth
+				hScale := float64(opt.h) / originHeight
+				if err = vipImage.Resize(math.Min(wScale, hScale), vips.KernelLinear); err != nil {
+					log.Context(ctx).Errorf("vips resize error: %v", err)
+					return err
+				}
+			}
+		}
+	} else if opt.p > 0 {
+		if err := vipImage.Resize(float64(opt.p)/100, vips.KernelLinear); err != nil {
+			log.Context(ctx).Errorf("vips resize error: %v", err)
+			return err
 		}
+	} else {
+		return errors2.BadRequest("PARAM_ERROR", "Missing required param")
 	}
-	if sigma == 0 || radius == 0 {
-		return 0, 0, errors2.BadRequest("PARAM_ERROR", "Missing required param: sigma or radius")
+	return nil
+}
+
+// rotate turns the image clockwise. Right angles are lossless, any other angle grows the canvas to the
+// bounding box of the rotated image and leaves the new area transparent, the jpeg encoder later fills it white.
+func rotate(vipImage *vips.ImageRef, angle int) error {
+	switch angle {
+	case 0:
+		return nil
+	case 90:
+		return vipImage.Rotate(vips.Angle90)
+	case 180:
+		return vipImage.Rotate(vips.Angle180)
+	case 270:
+		return vipImage.Rotate(vips.Angle270)
+	}
+	targetWidth, targetHeight := rotatedSize(vipImage.Width(), vipImage.Height(), angle)
+
+	if err := vipImage.AddAlpha(); err != nil {
+		return err
+	}
+	if err := vipImage.PremultiplyAlpha(); err != nil {
+		return err
+	}
+	if err := vipImage.Similarity(1, float64(angle), &vips.ColorRGBA{}, 0, 0, 0, 0); err != nil {
+		return err
+	}
+	if err := vipImage.UnpremultiplyAlpha(); err != nil {
+		return err
+	}
+	//affine变换得到的画布可能比理论尺寸多出一两个像素，居中裁掉以保持和OSS一致的尺寸
+	if vipImage.Width() > targetWidth || vipImage.Height() > targetHeight {
+		targetWidth = int(math.Min(float64(targetWidth), float64(vipImage.Width())))
+		targetHeight = int(math.Min(float64(targetHeight), float64(vipImage.Height())))
+		return vipImage.ExtractArea(
+			(vipImage.Width()-targetWidth)/2,
+			(vipImage.Height()-targetHeight)/2,
+			targetWidth,
+			targetHeight,
+		)
+	}
+	return nil
+}
+
+// rotatedSize is the bounding box of a width x height image turned by angle degrees.
+func rotatedSize(width, height, angle int) (int, int) {
+	radians := float64(angle) * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(radians)), math.Abs(math.Cos(radians))
+	return int(math.Round(float64(width)*cos + float64(height)*sin)), int(math.Round(float64(width)*sin + float64(height)*cos))
+}
+
+// applyAlphaMask keeps only the pixels of every page of vipImage that are covered by the svg mask,
+// which has to be the size of a single page. Uncovered pixels become transparent.
+func applyAlphaMask(vipImage *vips.ImageRef, svg string) error {
+	mask, err := vips.NewImageFromBuffer([]byte(svg))
+	if err != nil {
+		return err
+	}
+	defer mask.Close()
+	if pages := vipImage.Height() / vipImage.PageHeight(); pages > 1 {
+		if err := mask.Replicate(1, pages); err != nil {
+			return err
+		}
+	}
+	if err := vipImage.AddAlpha(); err != nil {
+		return err
+	}
+	return vipImage.Composite(mask, vips.BlendModeDestIn, 0, 0)
+}
+
+type CropOpt struct {
+	w int
+	h int
+	x int
+	y int
+	g string
+}
+
+func parseCropOpt(op process.Operation) *CropOpt {
+	return &CropOpt{
+		w: op.Int("w", 0),
+		h: op.Int("h", 0),
+		x: op.Int("x", 0),
+		y: op.Int("y", 0),
+		g: op.String("g", "nw"),
+	}
+}
+
+// cropArea works out the region to extract like OSS does: g_ picks the top-left vertex of one of the nine
+// cells of a 3x3 grid over the image, x/y move right and down from it, and the box is clamped to the image.
+// An origin outside the image is rejected.
+func cropArea(imageWidth, imageHeight int, opt *CropOpt) (left, top, width, height int, err error) {
+	left, top = gridVertex(opt.g, imageWidth, imageHeight)
+	left += opt.x
+	top += opt.y
+	if left >= imageWidth || top >= imageHeight {
+		return 0, 0, 0, 0, errors2.BadRequest("InvalidArgument", "Advance cut's position is out of image.")
+	}
+	width, height = imageWidth-left, imageHeight-top
+	if opt.w > 0 && opt.w < width {
+		width = opt.w
+	}
+	if opt.h > 0 && opt.h < height {
+		height = opt.h
 	}
-	minAmpl = 1 - (math.Pow(radius/float64(50), 2) / float64(2))
 	return
 }
 
-func vipEncode(targetFormat string, vipImage *vips.ImageRef, quality int32) ([]byte, *vips.ImageMetadata, error) {
+// gridVertex returns the top-left vertex of the grid cell named by g, the image is split into thirds.
+func gridVertex(g string, width, height int) (left, top int) {
+	switch g {
+	case "north", "center", "south":
+		left = width / 3
+	case "ne", "east", "se":
+		left = width * 2 / 3
+	}
+	switch g {
+	case "west", "center", "east":
+		top = height / 3
+	case "sw", "south", "se":
+		top = height * 2 / 3
+	}
+	return
+}
+
+// gravityOffset returns the top-left position of a watermark inside the image for one of the nine OSS
+// gravities. x and y move the watermark away from the edge it is attached to, towards the centre.
+func gravityOffset(g string, outerWidth, outerHeight, innerWidth, innerHeight, x, y int) (left, top int) {
+	switch g {
+	case "nw", "west", "sw":
+		left = x
+	case "ne", "east", "se":
+		left = outerWidth - innerWidth - x
+	default:
+		left = (outerWidth-innerWidth)/2 + x
+	}
+	switch g {
+	case "nw", "north", "ne":
+		top = y
+	case "sw", "south", "se":
+		top = outerHeight - innerHeight - y
+	default:
+		top = (outerHeight-innerHeight)/2 + y
+	}
+	return
+}
+
+type EncodeOpt struct {
+	quality        int32
+	requestQuality int32
+	interlace      *bool
+	metadata       string
+	avif           *conf.Image_Avif
+	heif           *conf.Image_Heif
+	jxl            *conf.Image_Jxl
+}
+
+// lossyQuality is the quality used by the jpeg and webp encoders, which honour the quality operation.
+func (o *EncodeOpt) lossyQuality() int32 {
+	if o.requestQuality > 0 {
+		return o.requestQuality
+	}
+	return o.quality
+}
+
+// qualityOr returns the quality operation if the request has one, the configured format default otherwise.
+func (o *EncodeOpt) qualityOr(defaultQuality int32) int32 {
+	if o.requestQuality > 0 {
+		return o.requestQuality
+	}
+	return defaultQuality
+}
+
+// interlaceOr returns the interlace operation if the request has one, the format default otherwise.
+func (o *EncodeOpt) interlaceOr(defaultInterlace bool) bool {
+	if o.interlace != nil {
+		return *o.interlace
+	}
+	return defaultInterlace
+}
+
+func vipEncode(targetFormat string, vipImage *vips.ImageRef, encodeOpt *EncodeOpt) ([]byte, *vips.ImageMetadata, error) {
+	quality := encodeOpt.quality
 	var buf []byte
 	var err error
 	var metadata *vips.ImageMetadata
+	if err = stripMetadata(vipImage, encodeOpt.metadata); err != nil {
+		return nil, nil, err
+	}
 	switch targetFormat {
 	case "jpeg":
+		//jpeg不支持透明通道，透明区域按照OSS的处理方式填充为白色
+		if vipImage.HasAlpha() {
+			if err = vipImage.Flatten(&vips.Color{R: 255, G: 255, B: 255}); err != nil {
+				return nil, nil, err
+			}
+		}
 		buf, metadata, err = vipImage.ExportJpeg(&vips.JpegExportParams{
-			Quality:   int(quality),
-			Interlace: true,
+			Quality:   int(encodeOpt.lossyQuality()),
+			Interlace: encodeOpt.interlaceOr(true),
 		})
 	case "png":
 		buf, metadata, err = vipImage.ExportPng(&vips.PngExportParams{
 			Compression: 6,
-			Interlace:   false,
+			Interlace:   encodeOpt.interlaceOr(false),
 			Palette:     false,
 			Quality:     int(quality),
 		})
 	case "webp":
 		buf, metadata, err = vipImage.ExportWebp(&vips.WebpExportParams{
-			Quality:         int(quality),
+			Quality:         int(encodeOpt.lossyQuality()),
 			Lossless:        false,
 			NearLossless:    false,
 			ReductionEffort: 4,
@@ -474,21 +1363,52 @@ func vipEncode(targetFormat string, vipImage *vips.ImageRef, quality int32) ([]b
 		})
 	case "gif":
 		buf, metadata, err = vipImage.ExportGIF(&vips.GifExportParams{
-			Quality: int(quality),
+			Quality:   int(quality),
+			Interlace: encodeOpt.interlaceOr(false),
+		})
+	case "avif":
+		speed := encodeOpt.avif.GetSpeed()
+		if speed <= 0 {
+			speed = 5
+		}
+		buf, metadata, err = vipImage.ExportAvif(&vips.AvifExportParams{
+			Quality: int(encodeOpt.qualityOr(encodeOpt.avif.GetQuality())),
+			Speed:   int(speed),
+		})
+	case "heif":
+		effort := encodeOpt.heif.GetEffort()
+		if effort <= 0 {
+			effort = 4
+		}
+		buf, metadata, err = vipImage.ExportHeif(&vips.HeifExportParams{
+			Quality: int(encodeOpt.qualityOr(encodeOpt.heif.GetQuality())),
+			Effort:  int(effort),
 		})
+	case "jxl":
+		effort := encodeOpt.jxl.GetEffort()
+		if effort <= 0 {
+			effort = 7
+		}
+		buf, metadata, err = vipImage.ExportJxl(&vips.JxlExportParams{
+			Quality: int(encodeOpt.qualityOr(encodeOpt.jxl.GetQuality())),
+			Effort:  int(effort),
+		})
+	case "bmp":
+		buf, metadata, err = vipImage.ExportBmp()
+	case "jp2k":
+		params := vips.NewJp2kExportParams()
+		params.Quality = int(encodeOpt.lossyQuality())
+		buf, metadata, err = vipImage.ExportJp2k(params)
 	default:
 		buf, metadata, err = vipImage.ExportNative()
 	}
 	return buf, metadata, err
 }
 
-type operation struct {
-	operation string
-	opt       []string
-}
-
 func GetMimeTypeByVipImageType(code vips.ImageType) string {
 	switch code {
+	case vips.ImageTypeJPEG:
+		return "image/jpeg"
 	case vips.ImageTypePNG:
 		return "image/png"
 	case vips.ImageTypeWEBP:
@@ -499,8 +1419,20 @@ func GetMimeTypeByVipImageType(code vips.ImageType) string {
 		return "image/gif"
 	case vips.ImageTypeSVG:
 		return "image/svg+xml"
+	case vips.ImageTypeAVIF:
+		return "image/avif"
+	case vips.ImageTypeHEIF:
+		return "image/heic"
+	case vips.ImageTypeJXL:
+		return "image/jxl"
+	case vips.ImageTypeBMP:
+		return "image/bmp"
+	case vips.ImageTypeJP2K:
+		return "image/jp2"
+	case vips.ImageTypePDF:
+		return "application/pdf"
 	default:
-		return "image/jpeg"
+		return "application/octet-stream"
 	}
 }
 
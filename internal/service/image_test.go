@@ -0,0 +1,925 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/go-kratos/kratos/v2/errors"
+	"go-image-process/internal/process"
+	"go-image-process/internal/storage"
+	"go-image-process/internal/vips"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func uniform(c color.Color) image.Image {
+	var img draw.Image = image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	if _, ok := c.(color.NRGBA64); ok {
+		img = image.NewNRGBA64(img.Bounds())
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// TestTone checks bright and contrast on flat 8 and 16 bit images, the expected values follow
+// bright: v+value/100*max and contrast: f*(v-middle)+middle with f=259(c+255)/(255(259-c)),
+// c=value*2.55, both clipped to the band format.
+func TestTone(t *testing.T) {
+	tests := []struct {
+		process string
+		value   uint8
+		want    float64
+	}{
+		{"image/bright,50", 100, 227.5},
+		{"image/bright,-50", 100, 0},
+		{"image/bright,100", 100, 255},
+		{"image/bright,20", 200, 251},
+		{"image/contrast,50", 100, 45.28},
+		{"image/contrast,50", 200, 255},
+		{"image/contrast,-50", 100, 118.62},
+		{"image/contrast,-50", 200, 152.12},
+		{"image/contrast,-100", 30, 128},
+	}
+	for _, tt := range tests {
+		eight := loadTestImage(t, uniform(color.NRGBA{R: tt.value, G: tt.value, B: tt.value, A: 128}))
+		applyProcess(t, eight, tt.process)
+		got := pixel(t, eight, 1, 1)
+		for band := 0; band < 3; band++ {
+			if math.Abs(got[band]-tt.want) > 1 {
+				t.Errorf("%s on %d: band %d is %g, want %g", tt.process, tt.value, band, got[band], tt.want)
+			}
+		}
+		if got[3] != 128 {
+			t.Errorf("%s changed the alpha band to %g", tt.process, got[3])
+		}
+
+		//16位图的像素值为8位图的257倍，调整幅度应同比例放大
+		v16 := uint16(tt.value) * 257
+		sixteen := loadTestImage(t, uniform(color.NRGBA64{R: v16, G: v16, B: v16, A: 0x8080}))
+		applyProcess(t, sixteen, tt.process)
+		got = pixel(t, sixteen, 1, 1)
+		if math.Abs(got[0]-tt.want*257) > 257 {
+			t.Errorf("%s on 16 bit %d: %g, want %g", tt.process, v16, got[0], tt.want*257)
+		}
+		if got[3] != 0x8080 {
+			t.Errorf("%s changed the 16 bit alpha band to %g", tt.process, got[3])
+		}
+	}
+}
+
+// TestSharpen checks that sharpening leaves flat areas alone and that the overshoot next to an edge
+// grows with the value until vips caps it.
+func TestSharpen(t *testing.T) {
+	edge := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(100)
+			if x >= 8 {
+				v = 130
+			}
+			edge.Set(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+
+	previous := 130.0
+	for _, value := range []string{"50", "100", "200", "399"} {
+		vipImage := loadTestImage(t, edge)
+		applyProcess(t, vipImage, "image/sharpen,"+value)
+		if flat := pixel(t, vipImage, 1, 8)[0]; math.Abs(flat-100) > 1 {
+			t.Errorf("sharpen,%s changed a flat area to %g", value, flat)
+		}
+		overshoot := pixel(t, vipImage, 8, 8)[0]
+		if overshoot < previous || (value != "399" && overshoot == previous) {
+			t.Errorf("sharpen,%s: %g next to the edge, want more than %g", value, overshoot, previous)
+		}
+		previous = overshoot
+	}
+}
+
+// ossReferences are the operations compared with the outputs of OSS in testdata/oss, fetch.sh there
+// downloads them for source.png.
+var ossReferences = []string{"bright,50", "bright,-50", "contrast,50", "contrast,-50", "sharpen,100", "sharpen,300"}
+
+// TestOSSReference runs bright, contrast and sharpen on testdata/oss/source.png and compares the result with
+// the output of OSS for the same operation, the mean difference of every band must stay within 10 of 255.
+func TestOSSReference(t *testing.T) {
+	source, err := os.ReadFile("testdata/oss/source.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range ossReferences {
+		t.Run(op, func(t *testing.T) {
+			reference, err := os.ReadFile(filepath.Join("testdata", "oss", strings.ReplaceAll(op, ",", "_")+".png"))
+			if os.IsNotExist(err) {
+				t.Skip("no OSS output, run testdata/oss/fetch.sh")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := png.Decode(bytes.NewReader(reference))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			vipImage, err := vips.NewImageFromBuffer(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer vipImage.Close()
+			applyProcess(t, vipImage, "image/"+op)
+			buf, _, err := vipImage.ExportPng(vips.NewPngExportParams())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := png.Decode(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("%v, OSS returned %v", got.Bounds(), want.Bounds())
+			}
+
+			var diff [3]float64
+			bounds := got.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					r1, g1, b1, _ := got.At(x, y).RGBA()
+					r2, g2, b2, _ := want.At(x, y).RGBA()
+					diff[0] += math.Abs(float64(r1>>8) - float64(r2>>8))
+					diff[1] += math.Abs(float64(g1>>8) - float64(g2>>8))
+					diff[2] += math.Abs(float64(b1>>8) - float64(b2>>8))
+				}
+			}
+			for band, sum := range diff {
+				if mean := sum / float64(bounds.Dx()*bounds.Dy()); mean > 10 {
+					t.Errorf("band %d differs from OSS by %.1f on average", band, mean)
+				}
+			}
+		})
+	}
+}
+
+// TestStripMetadataAnimation runs metadata,icc on a two frame animation, the frames and their delays
+// must survive the encoder.
+func TestStripMetadataAnimation(t *testing.T) {
+	frames := image.NewNRGBA(image.Rect(0, 0, 8, 16))
+	draw.Draw(frames, image.Rect(0, 0, 8, 8), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(frames, image.Rect(0, 8, 8, 16), image.NewUniform(color.NRGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	vipImage := loadTestImage(t, frames)
+	if err := vipImage.SetPages(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := vipImage.SetPageHeight(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := vipImage.SetPageDelay([]int{100, 250}); err != nil {
+		t.Fatal(err)
+	}
+
+	delay, err := vipImage.PageDelay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodeOpt := applyProcess(t, vipImage, "image/resize,w_4/metadata,icc")
+	if err := restorePages(vipImage, "webp", delay); err != nil {
+		t.Fatal(err)
+	}
+	buf, _, err := vipEncode("webp", vipImage, encodeOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importParams := vips.NewImportParams()
+	importParams.NumPages.Set(-1)
+	encoded, err := vips.LoadImageFromBuffer(buf, importParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoded.Close()
+	if encoded.Pages() != 2 || encoded.PageHeight() != 4 {
+		t.Fatalf("encoded %d pages of height %d, want 2 of height 4", encoded.Pages(), encoded.PageHeight())
+	}
+	if got, _ := encoded.PageDelay(); !reflect.DeepEqual(got, []int{100, 250}) {
+		t.Errorf("encoded delays %v, want [100 250]", got)
+	}
+}
+
+// TestStripMetadata encodes testdata/gps.jpg, which carries gps tags, a body and a lens serial number,
+// orientation 3 and an icc profile, with metadata,privacy and metadata,icc and reads the jpeg back.
+func TestStripMetadata(t *testing.T) {
+	buf, err := os.ReadFile("testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		mode     string
+		keepMake bool
+	}{
+		{"privacy", true},
+		{"icc", false},
+	} {
+		vipImage, err := vips.NewImageFromBuffer(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := strings.Join(vipImage.ImageFields(), " ")
+		if !strings.Contains(fields, "exif-ifd3-GPSLatitude") || !strings.Contains(fields, "exif-ifd2-BodySerialNumber") {
+			t.Fatalf("the fixture lacks the private tags: %s", fields)
+		}
+		encodeOpt := applyProcess(t, vipImage, "image/metadata,"+tt.mode)
+		encodeOpt.quality = 90
+		out, _, err := vipEncode("jpeg", vipImage, encodeOpt)
+		vipImage.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := vips.NewImageFromBuffer(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range encoded.ImageFields() {
+			if strings.HasPrefix(field, "exif-ifd3-") || strings.HasSuffix(field, "SerialNumber") {
+				t.Errorf("metadata,%s keeps %s", tt.mode, field)
+			}
+		}
+		if encoded.Orientation() != 3 {
+			t.Errorf("metadata,%s: orientation %d, want 3", tt.mode, encoded.Orientation())
+		}
+		if !encoded.HasICCProfile() {
+			t.Errorf("metadata,%s dropped the icc profile", tt.mode)
+		}
+		if _, ok := encoded.GetString("exif-ifd0-Make"); ok != tt.keepMake {
+			t.Errorf("metadata,%s: Make is kept %v, want %v", tt.mode, ok, tt.keepMake)
+		}
+		encoded.Close()
+	}
+}
+
+func TestWatermarkFont(t *testing.T) {
+	i := Image{fonts: map[string]string{"fangzhengshusong": "FZShuSong-Z01"}}
+	if family, err := i.watermarkFont(&WatermarkOpt{font: "fangzhengshusong"}); err != nil || family != "FZShuSong-Z01" {
+		t.Errorf("mapped font resolved to %q, %v", family, err)
+	}
+	//测试环境没有注册字体目录，未配置的字体应报错而不是悄悄换成其他字体
+	if _, err := i.watermarkFont(&WatermarkOpt{font: "fangzhengkaiti"}); errors.Reason(err) != "InvalidArgument" {
+		t.Errorf("unknown font: got %v, want InvalidArgument", err)
+	}
+	//没有指定type_时，默认字体缺失则使用系统字体
+	if family, err := i.watermarkFont(&WatermarkOpt{}); err != nil || family != fallbackWatermarkFont {
+		t.Errorf("missing default font resolved to %q, %v", family, err)
+	}
+	i.fonts[defaultWatermarkFont] = "WenQuanYi Zen Hei"
+	if family, err := i.watermarkFont(&WatermarkOpt{}); err != nil || family != "WenQuanYi Zen Hei" {
+		t.Errorf("default font resolved to %q, %v", family, err)
+	}
+}
+
+// TestWatermarkOffset places a 40x20 watermark on a 200x100 image with x_10, y_5 and voffset_8 at
+// every gravity, voffset_ only applies to the middle row and x_ never to the middle column.
+func TestWatermarkOffset(t *testing.T) {
+	tests := []struct {
+		g         string
+		voffset   int
+		left, top int
+	}{
+		{"nw", 8, 10, 5},
+		{"north", 8, 80, 5},
+		{"ne", 8, 150, 5},
+		{"west", 8, 10, 32},
+		{"center", 8, 80, 32},
+		{"east", 8, 150, 32},
+		{"sw", 8, 10, 75},
+		{"south", 8, 80, 75},
+		{"se", 8, 150, 75},
+		{"center", -8, 80, 48},
+		{"west", 0, 10, 40},
+		{"nw", -8, 10, 5},
+		{"se", 1000, 150, 75},
+	}
+	for _, tt := range tests {
+		opt := &WatermarkOpt{g: tt.g, x: 10, y: 5, voffset: tt.voffset}
+		left, top := watermarkOffset(opt, 200, 100, 40, 20)
+		if left != tt.left || top != tt.top {
+			t.Errorf("g_%s,voffset_%d: placed at %d,%d, want %d,%d", tt.g, tt.voffset, left, top, tt.left, tt.top)
+		}
+	}
+}
+
+// TestPlaceWatermarkFill tiles a 10x10 watermark with padx_5 and pady_20 over a white image.
+func TestPlaceWatermarkFill(t *testing.T) {
+	vipImage := loadTestImage(t, uniform(color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+	if err := vipImage.Embed(0, 0, 100, 100, vips.ExtendCopy); err != nil {
+		t.Fatal(err)
+	}
+	//透明度不是255时png会保留alpha通道，瓦片之间的间隔才是透明的
+	watermark := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(watermark, watermark.Bounds(), image.NewUniform(color.NRGBA{A: 254}), image.Point{}, draw.Src)
+	watermarkImage := loadTestImage(t, watermark)
+
+	opt := &WatermarkOpt{fill: 1, g: "se", x: 30, y: 30, padx: 5, pady: 20}
+	if err := placeWatermark(vipImage, watermarkImage, opt); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		x, y int
+		dark bool
+	}{
+		{0, 0, true}, {9, 9, true}, {12, 5, false}, {16, 5, true}, {5, 12, false}, {5, 29, false}, {5, 31, true}, {31, 31, true},
+	} {
+		if dark := pixel(t, vipImage, tt.x, tt.y)[0] < 10; dark != tt.dark {
+			t.Errorf("pixel %d,%d dark is %v, want %v", tt.x, tt.y, dark, tt.dark)
+		}
+	}
+}
+
+// TestWatermarkImageProcess runs the OSS form of a scaled logo, resize,P_30 inside the chain of the
+// watermark image scales the 40x20 logo to 30% of the 200x100 main image.
+func TestWatermarkImageProcess(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "bucket"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewNRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "bucket", "logo.png"), logo.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := Image{storage: storage.NewLocal(root)}
+	watermark := func(object string) error {
+		vipImage := loadTestImage(t, uniform(color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+		if err := vipImage.Embed(0, 0, 200, 100, vips.ExtendCopy); err != nil {
+			t.Fatal(err)
+		}
+		operations, err := process.Parse("image/watermark,g_nw,x_0,y_0,image_" + base64.URLEncoding.EncodeToString([]byte(object)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt, err := parseWatermarkOpt(operations[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		watermarkImage, err := i.loadWatermarkImage(context.Background(), opt, "bucket", vipImage.Width(), vipImage.PageHeight())
+		if err != nil {
+			return err
+		}
+		defer watermarkImage.Close()
+		if watermarkImage.Width() != 60 || watermarkImage.Height() != 30 {
+			t.Errorf("%s: watermark of %dx%d, want 60x30", object, watermarkImage.Width(), watermarkImage.Height())
+		}
+		return nil
+	}
+
+	for _, object := range []string{
+		"logo.png?x-oss-process=image/resize,P_30",
+		"logo.png?x-oss-process=image/auto-orient,1/resize,P_30/format,png",
+	} {
+		if err := watermark(object); err != nil {
+			t.Errorf("%s: %v", object, err)
+		}
+	}
+	for _, object := range []string{
+		"logo.png?x-oss-process=image/resize,P_30/info",
+		"logo.png?x-oss-process=image/watermark,text_SGVsbG8",
+	} {
+		if err := watermark(object); errors.Reason(err) != "InvalidArgument" {
+			t.Errorf("%s: got %v, want InvalidArgument", object, err)
+		}
+	}
+
+	//P_是相对主图的比例，主图上直接使用没有意义
+	operations, err := process.Parse("image/resize,P_30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vipImage := loadTestImage(t, uniform(color.NRGBA{A: 255}))
+	if err := (Image{}).processImage(context.Background(), vipImage, "", operations, &EncodeOpt{}); errors.Reason(err) != "InvalidArgument" {
+		t.Errorf("resize,P_30 on the main image: got %v, want InvalidArgument", err)
+	}
+}
+
+// renderText renders a text watermark and reads it back through png.
+func renderText(t *testing.T, i Image, opt *WatermarkOpt) image.Image {
+	t.Helper()
+	textImage, err := i.textWatermarkImage(context.Background(), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer textImage.Close()
+	buf, _, err := textImage.ExportPng(vips.NewPngExportParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rendered
+}
+
+func TestParseWatermarkColor(t *testing.T) {
+	tests := []struct {
+		color string
+		want  vips.Color
+		alpha float32
+	}{
+		{"FF0000", vips.Color{R: 255}, 1},
+		{"00ff7f", vips.Color{G: 255, B: 127}, 1},
+		{"FF000080", vips.Color{R: 255}, float32(0x80) / 0xff},
+		{"12345600", vips.Color{R: 0x12, G: 0x34, B: 0x56}, 0},
+	}
+	for _, tt := range tests {
+		got, alpha, err := parseWatermarkColor(tt.color)
+		if err != nil || got != tt.want || alpha != tt.alpha {
+			t.Errorf("parseWatermarkColor(%q) = %v, %g, %v, want %v, %g", tt.color, got, alpha, err, tt.want, tt.alpha)
+		}
+	}
+	for _, invalid := range []string{"", "FFF", "GG0000", "FF00000", "FF0000FF00"} {
+		if _, _, err := parseWatermarkColor(invalid); err == nil {
+			t.Errorf("parseWatermarkColor(%q) accepted", invalid)
+		}
+	}
+}
+
+// inkCentre returns how many pixels of the rendered text match and where their centre is.
+func inkCentre(rendered image.Image, match func(r, g, b, a uint32) bool) (n int, x, y float64) {
+	bounds := rendered.Bounds()
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			r, g, b, a := rendered.At(px, py).RGBA()
+			if match(r>>8, g>>8, b>>8, a>>8) {
+				n++
+				x += float64(px)
+				y += float64(py)
+			}
+		}
+	}
+	if n > 0 {
+		x, y = x/float64(n), y/float64(n)
+	}
+	return
+}
+
+// TestTextWatermarkShadow renders white text, shadow_ adds dark pixels to the bottom right of it.
+func TestTextWatermarkShadow(t *testing.T) {
+	i := Image{fonts: map[string]string{defaultWatermarkFont: "Sans"}}
+	white := func(r, g, b, a uint32) bool { return a > 128 && r > 200 }
+	dark := func(r, g, b, a uint32) bool { return a > 64 && r < 80 }
+	for _, shadow := range []int{0, 100} {
+		opt := &WatermarkOpt{text: "II", color: "FFFFFF", strokeColor: "000000", t: 100, size: 40, shadow: shadow}
+		rendered := renderText(t, i, opt)
+		inked, inkX, inkY := inkCentre(rendered, white)
+		shaded, shadowX, shadowY := inkCentre(rendered, dark)
+		if inked == 0 {
+			t.Fatalf("shadow_%d: no text rendered", shadow)
+		}
+		if shadow == 0 {
+			if shaded > 0 {
+				t.Errorf("shadow_0: %d dark pixels", shaded)
+			}
+			continue
+		}
+		if shaded == 0 || shadowX <= inkX || shadowY <= inkY {
+			t.Errorf("shadow_%d: %d dark pixels around %.1f,%.1f, the text is around %.1f,%.1f",
+				shadow, shaded, shadowX, shadowY, inkX, inkY)
+		}
+	}
+}
+
+// TestTextWatermarkStroke renders black text with a red stroke_, the stroke grows the ink area.
+func TestTextWatermarkStroke(t *testing.T) {
+	i := Image{fonts: map[string]string{defaultWatermarkFont: "Sans"}}
+	ink := func(r, g, b, a uint32) bool { return a > 128 }
+	red := func(r, g, b, a uint32) bool { return a > 128 && r > 200 && g < 80 }
+
+	plain := &WatermarkOpt{text: "II", color: "000000", strokeColor: "FF0000", t: 100, size: 40}
+	plainInk, _, _ := inkCentre(renderText(t, i, plain), ink)
+	stroked := *plain
+	stroked.stroke = 3
+	rendered := renderText(t, i, &stroked)
+	strokedInk, _, _ := inkCentre(rendered, ink)
+	if strokedInk <= plainInk {
+		t.Errorf("stroke_3 covers %d pixels, without stroke %d", strokedInk, plainInk)
+	}
+	if n, _, _ := inkCentre(rendered, red); n == 0 {
+		t.Errorf("stroke_3 drew no pixels in the stroke color")
+	}
+}
+
+// TestTextWatermarkRotate renders text heavy at its start, rotated clockwise by 90 the start is on top
+// and by 270 at the bottom.
+func TestTextWatermarkRotate(t *testing.T) {
+	i := Image{fonts: map[string]string{defaultWatermarkFont: "Sans"}}
+	for _, tt := range []struct {
+		rotate int
+		top    bool
+	}{
+		{90, true},
+		{270, false},
+	} {
+		opt := &WatermarkOpt{text: "WWWW....", color: "000000", strokeColor: "000000", t: 100, size: 40, rotate: tt.rotate}
+		rendered := renderText(t, i, opt)
+		if rendered.Bounds().Dy() <= rendered.Bounds().Dx() {
+			t.Errorf("rotate_%d: %v is not upright", tt.rotate, rendered.Bounds())
+		}
+		var ink, weighted float64
+		bounds := rendered.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				_, _, _, a := rendered.At(x, y).RGBA()
+				ink += float64(a)
+				weighted += float64(a) * float64(y)
+			}
+		}
+		if top := weighted/ink < float64(bounds.Dy())/2; top != tt.top {
+			t.Errorf("rotate_%d: the start of the text is on top %v, want %v", tt.rotate, top, tt.top)
+		}
+	}
+}
+
+// TestRoundedCornersFrames rounds a two frame 40x80 strip with and without an alpha band, the corners of
+// every frame become transparent while the centre keeps its alpha.
+func TestRoundedCornersFrames(t *testing.T) {
+	for _, alpha := range []uint8{255, 200} {
+		frames := image.NewNRGBA(image.Rect(0, 0, 40, 80))
+		draw.Draw(frames, frames.Bounds(), image.NewUniform(color.NRGBA{R: 255, A: alpha}), image.Point{}, draw.Src)
+		vipImage := loadTestImage(t, frames)
+		if vipImage.HasAlpha() != (alpha != 255) {
+			t.Fatalf("alpha %d loaded with alpha band %v", alpha, vipImage.HasAlpha())
+		}
+		if err := vipImage.SetPages(2); err != nil {
+			t.Fatal(err)
+		}
+		if err := vipImage.SetPageHeight(40); err != nil {
+			t.Fatal(err)
+		}
+
+		applyProcess(t, vipImage, "image/rounded-corners,r_10")
+		if vipImage.Width() != 40 || vipImage.Height() != 80 || vipImage.Bands() != 4 {
+			t.Fatalf("alpha %d: %dx%d with %d bands, want 40x80 with 4", alpha, vipImage.Width(), vipImage.Height(), vipImage.Bands())
+		}
+		for _, top := range []int{0, 40} {
+			for _, corner := range [][2]int{{0, 0}, {39, 0}, {0, 39}, {39, 39}} {
+				if a := pixel(t, vipImage, corner[0], top+corner[1])[3]; a != 0 {
+					t.Errorf("alpha %d: corner %d,%d of the frame at %d has alpha %g", alpha, corner[0], corner[1], top, a)
+				}
+			}
+			if a := pixel(t, vipImage, 20, top+20)[3]; a != float64(alpha) {
+				t.Errorf("alpha %d: the centre of the frame at %d has alpha %g", alpha, top, a)
+			}
+		}
+	}
+}
+
+// TestCircle cuts circles out of a 60x40 image, the radius is clamped to half of the short side.
+func TestCircle(t *testing.T) {
+	tests := []struct {
+		process string
+		size    int
+	}{
+		{"image/circle,r_10", 20},
+		{"image/circle,r_20", 40},
+		{"image/circle,r_100", 40},
+		{"image/circle", 40},
+	}
+	for _, tt := range tests {
+		vipImage := loadTestImage(t, uniform(color.NRGBA{R: 255, A: 255}))
+		if err := vipImage.Embed(0, 0, 60, 40, vips.ExtendCopy); err != nil {
+			t.Fatal(err)
+		}
+		applyProcess(t, vipImage, tt.process)
+		if vipImage.Width() != tt.size || vipImage.Height() != tt.size {
+			t.Errorf("%s: %dx%d, want %dx%d", tt.process, vipImage.Width(), vipImage.Height(), tt.size, tt.size)
+			continue
+		}
+		if a := pixel(t, vipImage, 0, 0)[3]; a != 0 {
+			t.Errorf("%s: the corner has alpha %g", tt.process, a)
+		}
+		if a := pixel(t, vipImage, tt.size/2, tt.size/2)[3]; a != 255 {
+			t.Errorf("%s: the centre has alpha %g", tt.process, a)
+		}
+	}
+}
+
+// TestCircleCorners encodes a circle, the corners are transparent in png and white in jpeg, which has no
+// alpha band.
+func TestCircleCorners(t *testing.T) {
+	for _, format := range []string{"png", "jpeg"} {
+		vipImage := loadTestImage(t, uniform(color.NRGBA{R: 255, A: 255}))
+		if err := vipImage.Embed(0, 0, 40, 40, vips.ExtendCopy); err != nil {
+			t.Fatal(err)
+		}
+		encodeOpt := applyProcess(t, vipImage, "image/circle,r_20")
+		encodeOpt.quality = 90
+		buf, _, err := vipEncode(format, vipImage, encodeOpt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, _, err := image.Decode(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, b, a := encoded.At(0, 0).RGBA()
+		switch format {
+		case "png":
+			if a != 0 {
+				t.Errorf("png corner %d %d %d %d, want transparent", r>>8, g>>8, b>>8, a>>8)
+			}
+		case "jpeg":
+			if r>>8 < 245 || g>>8 < 245 || b>>8 < 245 {
+				t.Errorf("jpeg corner %d %d %d, want white", r>>8, g>>8, b>>8)
+			}
+		}
+		if r, g, b, _ := encoded.At(20, 20).RGBA(); r>>8 < 230 || g>>8 > 30 || b>>8 > 30 {
+			t.Errorf("%s centre %d %d %d, want red", format, r>>8, g>>8, b>>8)
+		}
+	}
+}
+
+// TestQualityResolve checks that Q_ is absolute while q_ is relative to the quality of a jpeg source and
+// never exceeds it, sourceQuality 0 stands for sources of other formats.
+func TestQualityResolve(t *testing.T) {
+	tests := []struct {
+		process       string
+		sourceQuality int
+		want          int32
+	}{
+		{"image/quality,Q_90", 75, 90},
+		{"image/quality,Q_50", 90, 50},
+		{"image/quality,Q_90", 0, 90},
+		{"image/quality,q_50", 80, 40},
+		{"image/quality,q_100", 80, 80},
+		{"image/quality,q_90", 0, 90},
+		{"image/quality,q_1", 10, 1},
+		{"image/quality,q_85", 99, 84},
+		{"image/quality,q_50,Q_90", 80, 90},
+	}
+	for _, tt := range tests {
+		operations, err := process.Parse(tt.process)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt, err := parseQualityOpt(operations[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := opt.resolve(tt.sourceQuality); got != tt.want {
+			t.Errorf("%s of a %d source: %d, want %d", tt.process, tt.sourceQuality, got, tt.want)
+		}
+	}
+
+	for source := 1; source <= 100; source++ {
+		for q := 1; q <= 100; q++ {
+			if got := (&QualityOpt{q: q}).resolve(source); got > int32(source) || got < 1 {
+				t.Fatalf("q_%d of a %d source: %d", q, source, got)
+			}
+		}
+	}
+}
+
+// TestEncodeFormats encodes with every format of the format operation, the metadata, the mime type and
+// the bytes written must all agree. Formats the libvips at hand can't handle are skipped.
+func TestEncodeFormats(t *testing.T) {
+	tests := []struct {
+		format    string
+		imageType vips.ImageType
+		mime      string
+	}{
+		{"jpg", vips.ImageTypeJPEG, "image/jpeg"},
+		{"png", vips.ImageTypePNG, "image/png"},
+		{"webp", vips.ImageTypeWEBP, "image/webp"},
+		{"tiff", vips.ImageTypeTIFF, "image/tiff"},
+		{"gif", vips.ImageTypeGIF, "image/gif"},
+		{"avif", vips.ImageTypeAVIF, "image/avif"},
+		{"heic", vips.ImageTypeHEIF, "image/heic"},
+		{"heif", vips.ImageTypeHEIF, "image/heic"},
+		{"jxl", vips.ImageTypeJXL, "image/jxl"},
+		{"bmp", vips.ImageTypeBMP, "image/bmp"},
+		{"jp2", vips.ImageTypeJP2K, "image/jp2"},
+	}
+	for _, tt := range tests {
+		//bmp由png转换而来，不依赖libvips的bmp支持
+		if tt.imageType != vips.ImageTypeBMP && !vips.IsTypeSupported(tt.imageType) {
+			t.Logf("format,%s is not supported by this libvips", tt.format)
+			continue
+		}
+		vipImage := loadTestImage(t, uniform(color.NRGBA{R: 255, A: 255}))
+		buf, metadata, err := vipEncode(outputFormats[tt.format], vipImage, &EncodeOpt{quality: 80})
+		if err != nil {
+			t.Errorf("format,%s: %v", tt.format, err)
+			continue
+		}
+		if metadata.Format != tt.imageType || GetMimeTypeByVipImageType(metadata.Format) != tt.mime {
+			t.Errorf("format,%s: encoded as %v %s, want %v %s", tt.format, metadata.Format,
+				GetMimeTypeByVipImageType(metadata.Format), tt.imageType, tt.mime)
+		}
+		if got := vips.DetermineImageType(buf); got != tt.imageType {
+			t.Errorf("format,%s: wrote %v", tt.format, got)
+		}
+	}
+
+	//没有对应mime的类型按二进制返回
+	for _, imageType := range []vips.ImageType{vips.ImageTypeUnknown, vips.ImageTypeMagick} {
+		if mime := GetMimeTypeByVipImageType(imageType); mime != "application/octet-stream" {
+			t.Errorf("%v: mime %s, want application/octet-stream", imageType, mime)
+		}
+	}
+}
+
+// TestCropArea crops a 100x80 image. Following the OSS definition g_ starts the box at the top-left vertex
+// of a cell of the 3x3 grid, i.e. at x 0, 33 or 66 and y 0, 26 or 53, boxes are clamped to the image and
+// origins outside, after adding x_/y_, are rejected.
+func TestCropArea(t *testing.T) {
+	tests := []struct {
+		process                  string
+		left, top, width, height int
+	}{
+		{"image/crop,x_10,y_10,w_50,h_40", 10, 10, 50, 40},
+		{"image/crop,x_10", 10, 0, 90, 80},
+		{"image/crop,x_60,w_50", 60, 0, 40, 80},
+		{"image/crop,y_70,h_500", 0, 70, 100, 10},
+		{"image/crop,w_500,h_500", 0, 0, 100, 80},
+		{"image/crop,x_99,y_79", 99, 79, 1, 1},
+		{"image/crop,w_10,h_10,g_nw", 0, 0, 10, 10},
+		{"image/crop,w_10,h_10,g_north", 33, 0, 10, 10},
+		{"image/crop,w_10,h_10,g_ne", 66, 0, 10, 10},
+		{"image/crop,w_10,h_10,g_west", 0, 26, 10, 10},
+		{"image/crop,w_10,h_10,g_center", 33, 26, 10, 10},
+		{"image/crop,w_10,h_10,g_east", 66, 26, 10, 10},
+		{"image/crop,w_10,h_10,g_sw", 0, 53, 10, 10},
+		{"image/crop,w_10,h_10,g_south", 33, 53, 10, 10},
+		{"image/crop,w_10,h_10,g_se", 66, 53, 10, 10},
+		{"image/crop,g_se", 66, 53, 34, 27},
+		{"image/crop,w_30,h_20,x_5,y_5,g_se", 71, 58, 29, 20},
+		{"image/crop,w_40,h_40,x_40,g_center", 73, 26, 27, 40},
+		{"image/crop,w_40,h_40,g_south", 33, 53, 40, 27},
+		{"image/crop,y_10,g_east", 66, 36, 34, 44},
+	}
+	for _, tt := range tests {
+		operations, err := process.Parse(tt.process)
+		if err != nil {
+			t.Fatal(err)
+		}
+		left, top, width, height, err := cropArea(100, 80, parseCropOpt(operations[0]))
+		if err != nil || left != tt.left || top != tt.top || width != tt.width || height != tt.height {
+			t.Errorf("%s: %d,%d %dx%d %v, want %d,%d %dx%d", tt.process, left, top, width, height, err,
+				tt.left, tt.top, tt.width, tt.height)
+		}
+	}
+
+	for _, outside := range []string{"image/crop,x_100", "image/crop,y_80", "image/crop,x_500,y_500,w_10,h_10",
+		"image/crop,x_34,g_se", "image/crop,y_27,g_south", "image/crop,x_67,y_10,g_north"} {
+		operations, err := process.Parse(outside)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, _, err := cropArea(100, 80, parseCropOpt(operations[0])); errors.Reason(err) != "InvalidArgument" {
+			t.Errorf("%s: got %v, want InvalidArgument", outside, err)
+		}
+	}
+}
+
+// TestIndexCropArea cuts a 100x80 image into 30 wide or 25 high strips, the last one is partial and
+// indexes past it keep the image.
+func TestIndexCropArea(t *testing.T) {
+	tests := []struct {
+		process                  string
+		left, top, width, height int
+		ok                       bool
+	}{
+		{"image/indexcrop,x_30,i_0", 0, 0, 30, 80, true},
+		{"image/indexcrop,x_30,i_2", 60, 0, 30, 80, true},
+		{"image/indexcrop,x_30,i_3", 90, 0, 10, 80, true},
+		{"image/indexcrop,x_30,i_4", 0, 0, 0, 0, false},
+		{"image/indexcrop,x_100,i_0", 0, 0, 100, 80, true},
+		{"image/indexcrop,x_500,i_0", 0, 0, 100, 80, true},
+		{"image/indexcrop,x_500,i_1", 0, 0, 0, 0, false},
+		{"image/indexcrop,y_25,i_1", 0, 25, 100, 25, true},
+		{"image/indexcrop,y_25,i_3", 0, 75, 100, 5, true},
+		{"image/indexcrop,y_25,i_4", 0, 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		operations, err := process.Parse(tt.process)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt, err := parseIndexCropOpt(operations[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		left, top, width, height, ok := indexCropArea(100, 80, opt)
+		if ok != tt.ok || (ok && (left != tt.left || top != tt.top || width != tt.width || height != tt.height)) {
+			t.Errorf("%s: %d,%d %dx%d %v, want %d,%d %dx%d %v", tt.process, left, top, width, height, ok,
+				tt.left, tt.top, tt.width, tt.height, tt.ok)
+		}
+	}
+
+	for _, invalid := range []string{"image/indexcrop,i_1", "image/indexcrop,x_10,y_10"} {
+		operations, err := process.Parse(invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseIndexCropOpt(operations[0]); errors.Reason(err) != "InvalidArgument" {
+			t.Errorf("%s: got %v, want InvalidArgument", invalid, err)
+		}
+	}
+}
+
+func TestRotatedSize(t *testing.T) {
+	tests := []struct {
+		angle         int
+		width, height int
+	}{
+		{0, 100, 50},
+		{30, 112, 93},
+		{45, 106, 106},
+		{90, 50, 100},
+		{135, 106, 106},
+		{180, 100, 50},
+		{270, 50, 100},
+		{359, 101, 52},
+	}
+	for _, tt := range tests {
+		if width, height := rotatedSize(100, 50, tt.angle); width != tt.width || height != tt.height {
+			t.Errorf("rotate,%d: %dx%d, want %dx%d", tt.angle, width, height, tt.width, tt.height)
+		}
+	}
+}
+
+// TestRotate rotates every frame of a two frame 100x50 animation, the canvas of each frame is the
+// bounding box of the rotated frame.
+func TestRotate(t *testing.T) {
+	for _, tt := range []struct {
+		angle         string
+		width, height int
+	}{
+		{"90", 50, 100},
+		{"30", 112, 93},
+		{"45", 106, 106},
+		{"360", 100, 50},
+	} {
+		vipImage := loadTestImage(t, image.NewNRGBA(image.Rect(0, 0, 100, 100)))
+		if err := vipImage.SetPages(2); err != nil {
+			t.Fatal(err)
+		}
+		if err := vipImage.SetPageHeight(50); err != nil {
+			t.Fatal(err)
+		}
+		applyProcess(t, vipImage, "image/rotate,"+tt.angle)
+		if vipImage.Width() != tt.width || vipImage.PageHeight() != tt.height || vipImage.Height() != 2*tt.height {
+			t.Errorf("rotate,%s: %dx%d with pages of height %d, want %dx%d", tt.angle,
+				vipImage.Width(), vipImage.Height(), vipImage.PageHeight(), tt.width, 2*tt.height)
+		}
+	}
+}
+
+// TestAutoOrientFrames turns a two frame 100x50 animation with orientation 6, each frame is rotated by
+// 90 degrees clockwise on its own and the marker in its top-left corner ends up top-right.
+func TestAutoOrientFrames(t *testing.T) {
+	frames := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(frames, frames.Bounds(), image.NewUniform(color.NRGBA{R: 255, G: 255, B: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(frames, image.Rect(0, 0, 5, 5), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(frames, image.Rect(0, 50, 5, 55), image.NewUniform(color.NRGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	vipImage := loadTestImage(t, frames)
+	if err := vipImage.SetPages(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := vipImage.SetPageHeight(50); err != nil {
+		t.Fatal(err)
+	}
+	if err := vipImage.SetOrientation(6); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := autoOrient(vipImage); err != nil {
+		t.Fatal(err)
+	}
+	if vipImage.Width() != 50 || vipImage.PageHeight() != 100 || vipImage.Height() != 200 {
+		t.Fatalf("%dx%d with pages of height %d, want 50x200 with pages of height 100",
+			vipImage.Width(), vipImage.Height(), vipImage.PageHeight())
+	}
+	if vipImage.GetOrientation() != 0 {
+		t.Errorf("orientation %d is kept", vipImage.GetOrientation())
+	}
+	if red := pixel(t, vipImage, 47, 2); red[0] < 200 || red[2] > 50 {
+		t.Errorf("top-right of the first frame is %v, want red", red)
+	}
+	if blue := pixel(t, vipImage, 47, 102); blue[2] < 200 || blue[0] > 50 {
+		t.Errorf("top-right of the second frame is %v, want blue", blue)
+	}
+}
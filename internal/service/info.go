@@ -0,0 +1,169 @@
+package service
+
+import (
+	"go-image-process/internal/vips"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// exifValue matches the way libvips formats exif tags, e.g. "1 (Top-left, Short, 1 components, 2 bytes)".
+var exifValue = regexp.MustCompile(`^(.*?) \((.*), (\w+), (\d+) components?, (\d+) bytes?\)$`)
+
+// iptcDatasets names the commonly used datasets of the IPTC application record.
+var iptcDatasets = map[byte]string{
+	5:   "ObjectName",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	95:  "Province-State",
+	100: "Country-PrimaryLocationCode",
+	101: "Country-PrimaryLocationName",
+	103: "OriginalTransmissionReference",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// imageInfo returns what OSS info does, every field as {"value": ...}: the size and format, all exif
+// tags by their exif name, IPTC datasets as IPTC:<name> and the XMP packet as XMP.
+func imageInfo(vipImage *vips.ImageRef, fileSize int) map[string]interface{} {
+	var info = make(map[string]interface{})
+	for _, field := range vipImage.ImageFields() {
+		//exif-ifd1是缩略图的信息，OSS不返回
+		if !strings.HasPrefix(field, "exif-ifd") || strings.HasPrefix(field, "exif-ifd1-") {
+			continue
+		}
+		parts := strings.SplitN(field, "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if value, ok := vipImage.GetString(field); ok {
+			info[parts[2]] = map[string]interface{}{"value": decodeExifValue(value)}
+		}
+	}
+	if iptc, ok := vipImage.GetBlob("iptc-data"); ok {
+		for name, value := range decodeIPTC(iptc) {
+			info["IPTC:"+name] = map[string]interface{}{"value": value}
+		}
+	}
+	if xmp, ok := vipImage.GetBlob("xmp-data"); ok {
+		info["XMP"] = map[string]interface{}{"value": strings.TrimRight(string(xmp), "\x00")}
+	}
+
+	info["FileSize"] = map[string]interface{}{"value": fileSize}
+	info["Format"] = map[string]interface{}{"value": vips.ImageTypes[vipImage.Format()]}
+	info["ImageHeight"] = map[string]interface{}{"value": vipImage.PageHeight()}
+	info["ImageWidth"] = map[string]interface{}{"value": vipImage.Width()}
+	return info
+}
+
+// decodeExifValue turns integers and rationals into numbers, or arrays of numbers when the tag has several
+// components such as GPSLatitude. Undefined tags such as ExifVersion use their readable form and everything
+// else, dates included, is kept as the tag is stored, e.g. 2023:05:06 07:08:09 like OSS returns it.
+func decodeExifValue(value string) interface{} {
+	match := exifValue.FindStringSubmatch(value)
+	if match == nil {
+		return value
+	}
+	raw, readable, format, components := match[1], match[2], match[3], match[4]
+	switch format {
+	case "Byte", "Short", "Long", "SByte", "SShort", "SLong":
+		if numbers, ok := decodeExifNumbers(raw, components, parseExifInt); ok {
+			return numbers
+		}
+	case "Rational", "SRational":
+		if numbers, ok := decodeExifNumbers(raw, components, parseExifRational); ok {
+			return numbers
+		}
+	case "Undefined":
+		return readable
+	}
+	return raw
+}
+
+// decodeExifNumbers parses the space separated components libvips writes for up to 9 components, larger
+// tags only come in their readable form and are left as they are.
+func decodeExifNumbers[T int | float64](raw string, components string, parse func(string) (T, bool)) (interface{}, bool) {
+	fields := strings.Fields(raw)
+	if strconv.Itoa(len(fields)) != components {
+		return nil, false
+	}
+	numbers := make([]T, len(fields))
+	for i, field := range fields {
+		n, ok := parse(field)
+		if !ok {
+			return nil, false
+		}
+		numbers[i] = n
+	}
+	if len(numbers) == 1 {
+		return numbers[0], true
+	}
+	return numbers, true
+}
+
+func parseExifInt(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// parseExifRational parses a rational such as 2732/100, rationals with a zero denominator are invalid.
+func parseExifRational(s string) (float64, bool) {
+	numerator, denominator, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(numerator, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	d, err := strconv.ParseInt(denominator, 10, 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+	return float64(n) / float64(d), true
+}
+
+// decodeIPTC reads the datasets of the application record, either from raw IIM data or from the
+// photoshop resource block jpeg keeps it in. Repeated datasets such as Keywords are joined with ";".
+func decodeIPTC(data []byte) map[string]string {
+	var values = make(map[string]string)
+	start := -1
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == 0x1c && data[i+1] == 0x02 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return values
+	}
+	for i := start; i+5 <= len(data) && data[i] == 0x1c; {
+		record, dataset := data[i+1], data[i+2]
+		length := int(data[i+3])<<8 | int(data[i+4])
+		i += 5
+		//扩展长度的数据集不常见，遇到时停止解析
+		if length&0x8000 != 0 || i+length > len(data) {
+			break
+		}
+		if name, ok := iptcDatasets[dataset]; ok && record == 0x02 {
+			if previous, ok := values[name]; ok {
+				values[name] = previous + ";" + string(data[i:i+length])
+			} else {
+				values[name] = string(data[i : i+length])
+			}
+		}
+		i += length
+	}
+	return values
+}
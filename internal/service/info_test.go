@@ -0,0 +1,74 @@
+package service
+
+import (
+	"go-image-process/internal/vips"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeExifValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"1 (Top-left, Short, 1 components, 2 bytes)", 1},
+		{"8 8 8 (8, 8, 8, Short, 3 components, 6 bytes)", []int{8, 8, 8}},
+		{"72/1 (72, Rational, 1 components, 8 bytes)", 72.0},
+		{"1/125 (1/125 sec., Rational, 1 components, 8 bytes)", 0.008},
+		{"-1/3 (-0.33 EV, SRational, 1 components, 8 bytes)", -1.0 / 3},
+		{"39/1 54/1 2732/100 (39, 54, 27.32, Rational, 3 components, 24 bytes)", []float64{39, 54, 27.32}},
+		{"1/0 (1/0, Rational, 1 components, 8 bytes)", "1/0"},
+		{"2023:05:06 07:08:09 (2023:05:06 07:08:09, ASCII, 20 components, 20 bytes)", "2023:05:06 07:08:09"},
+		{"2023:05:06 (2023:05:06, ASCII, 11 components, 11 bytes)", "2023:05:06"},
+		{"0000:00:00 00:00:00 (0000:00:00 00:00:00, ASCII, 20 components, 20 bytes)", "0000:00:00 00:00:00"},
+		{"Canon (Canon, ASCII, 6 components, 6 bytes)", "Canon"},
+		{"48 50 51 50 (Exif Version 2.32, Undefined, 4 components, 4 bytes)", "Exif Version 2.32"},
+		{"not formatted by libvips", "not formatted by libvips"},
+	}
+	for _, tt := range tests {
+		if got := decodeExifValue(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeExifValue(%q) = %#v, want %#v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestImageInfoGPS reads testdata/gps.jpg, a 16x8 jpeg whose exif places it at 39°54'27.32"N
+// 116°23'12.34"E and dates it 2023:05:06 07:08:09.
+func TestImageInfoGPS(t *testing.T) {
+	buf, err := os.ReadFile("testdata/gps.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vipImage, err := vips.NewImageFromBuffer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vipImage.Close()
+
+	info := imageInfo(vipImage, len(buf))
+	want := map[string]interface{}{
+		"GPSLatitudeRef":   "N",
+		"GPSLatitude":      []float64{39, 54, 27.32},
+		"GPSLongitudeRef":  "E",
+		"GPSLongitude":     []float64{116, 23, 12.34},
+		"GPSDateStamp":     "2023:05:06",
+		"DateTimeOriginal": "2023:05:06 07:08:09",
+		"DateTime":         "2023:05:06 07:08:09",
+		"ExposureTime":     0.008,
+		"Make":             "GoImageProcess",
+		"ImageWidth":       16,
+		"ImageHeight":      8,
+		"FileSize":         len(buf),
+	}
+	for name, value := range want {
+		field, ok := info[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s is missing", name)
+			continue
+		}
+		if !reflect.DeepEqual(field["value"], value) {
+			t.Errorf("%s = %#v, want %#v", name, field["value"], value)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"go-image-process/internal/process"
+	"go-image-process/internal/vips"
+	"image"
+	"image/png"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	vips.Startup(nil)
+	code := m.Run()
+	vips.Shutdown()
+	os.Exit(code)
+}
+
+// loadTestImage hands a Go image to vips through png, 16 bit images stay 16 bit.
+func loadTestImage(t *testing.T, img image.Image) *vips.ImageRef {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	vipImage, err := vips.NewImageFromBuffer(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(vipImage.Close)
+	return vipImage
+}
+
+// applyProcess runs an x-oss-process string on the image the way process does after loading.
+func applyProcess(t *testing.T, vipImage *vips.ImageRef, processOpt string) *EncodeOpt {
+	t.Helper()
+	operations, err := process.Parse(processOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodeOpt := &EncodeOpt{}
+	if err := (Image{}).processImage(context.Background(), vipImage, "", operations, encodeOpt); err != nil {
+		t.Fatalf("%s: %v", processOpt, err)
+	}
+	return encodeOpt
+}
+
+// pixel returns the bands of the pixel at x, y.
+func pixel(t *testing.T, vipImage *vips.ImageRef, x, y int) []float64 {
+	t.Helper()
+	point, err := vipImage.GetPoint(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return point
+}
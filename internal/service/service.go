@@ -10,4 +10,6 @@ var ProviderSet = wire.NewSet(NewImage)
 
 type ImageInterface interface {
 	ImageHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error)
+	ObjectHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error)
+	FetchHandler(ctx context.Context, httpContext transportHttp.Context) (interface{}, error)
 }
@@ -0,0 +1,9 @@
+#!/bin/sh
+# Fetches the OSS outputs TestOSSReference compares with. Upload source.png to a bucket readable by the
+# public first, e.g. ossutil cp source.png oss://<bucket>/source.png, then run
+# ./fetch.sh https://<bucket>.<endpoint>/source.png
+set -e
+cd "$(dirname "$0")"
+for op in bright,50 bright,-50 contrast,50 contrast,-50 sharpen,100 sharpen,300; do
+	curl -fsS -o "$(echo "$op" | tr , _).png" "$1?x-oss-process=image/$op/format,png"
+done
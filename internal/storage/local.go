@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local serves objects from the file system, every bucket is a directory under root.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (l *Local) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	if len(l.root) == 0 {
+		return nil, errors.InternalServer("StorageNotConfigured", "Local storage root is not configured.")
+	}
+	name, err := l.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoSuchKey
+		}
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.IsDir() {
+		_ = f.Close()
+		return nil, ErrNoSuchKey
+	}
+	return f, nil
+}
+
+// path maps bucket and key into root.
+func (l *Local) path(bucket string, key string) (string, error) {
+	if err := validate(bucket, key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, bucket, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"go-image-process/internal/conf"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the hex sha256 of an empty body, GET requests sign it as their payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 serves objects from an S3 compatible object storage. Requests use path style addressing and are
+// signed with AWS Signature Version 4, the object body is streamed to the caller as it arrives.
+type S3 struct {
+	endpoint        *url.URL
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	client          *http.Client
+	now             func() time.Time
+}
+
+func NewS3(s3Conf *conf.Storage_S3) (*S3, error) {
+	endpoint, err := url.Parse(s3Conf.GetEndpoint())
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+		return nil, fmt.Errorf("invalid s3 endpoint: %s", s3Conf.GetEndpoint())
+	}
+	region := s3Conf.GetRegion()
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s3Conf.GetTimeout() != nil {
+		transport.ResponseHeaderTimeout = s3Conf.GetTimeout().AsDuration()
+	}
+	return &S3{
+		endpoint:        endpoint,
+		region:          region,
+		accessKeyID:     s3Conf.GetAccessKeyId(),
+		secretAccessKey: s3Conf.GetSecretAccessKey(),
+		sessionToken:    s3Conf.GetSessionToken(),
+		client:          &http.Client{Transport: transport},
+		now:             time.Now,
+	}, nil
+}
+
+func (s *S3) Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error) {
+	if err := validate(bucket, key); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.ServiceUnavailable("StorageUnavailable", fmt.Sprintf("s3 get %s/%s: %v", bucket, key, err))
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp)
+}
+
+// newRequest builds a signed request for the object, key segments are escaped once and the same
+// escaped path is sent and signed.
+func (s *S3) newRequest(ctx context.Context, method string, bucket string, key string) (*http.Request, error) {
+	segments := strings.Split(bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	base := strings.TrimSuffix(s.endpoint.EscapedPath(), "/")
+	u := *s.endpoint
+	u.RawPath = base + "/" + strings.Join(segments, "/")
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + bucket + "/" + key
+	u.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.accessKeyID) > 0 {
+		s.sign(req, u.RawPath)
+	}
+	return req, nil
+}
+
+// sign adds the Signature Version 4 headers, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3) sign(req *http.Request, canonicalURI string) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", emptyPayloadHash)
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": emptyPayloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if len(s.sessionToken) > 0 {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+		headers["x-amz-security-token"] = s.sessionToken
+	}
+
+	request := canonicalRequest(req.Method, canonicalURI, "", signedHeaders, headers, emptyPayloadHash)
+	scope, sig := signature(s.secretAccessKey, now, s.region, "s3", request)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, strings.Join(signedHeaders, ";"), sig))
+}
+
+// canonicalRequest joins the parts of a request that are signed, signedHeaders must be lower case and
+// sorted, and headers holds their trimmed values.
+func canonicalRequest(method string, canonicalURI string, canonicalQuery string, signedHeaders []string,
+	headers map[string]string, payloadHash string) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return strings.Join([]string{
+		method,
+		canonicalURI,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// signature signs the canonical request at t with the key derived for region and service, it returns
+// the credential scope and the hex signature.
+func signature(secretAccessKey string, t time.Time, region string, service string, canonicalRequest string) (string, string) {
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode escapes everything except the unreserved characters of RFC 3986, as SigV4 requires.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error maps an error response of the storage to the error returned to the client.
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	switch {
+	case body.Code == "NoSuchBucket":
+		return errors.NotFound("NoSuchBucket", "The specified bucket does not exist.")
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNoSuchKey
+	case resp.StatusCode == http.StatusForbidden:
+		return errors.Forbidden("AccessDenied", "Access to the object is denied by the storage.")
+	}
+	if len(body.Code) == 0 {
+		body.Code = resp.Status
+	}
+	return errors.New(http.StatusBadGateway, "StorageError", fmt.Sprintf("s3 %s: %s", body.Code, body.Message))
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"go-image-process/internal/conf"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignature checks the signer against known answers published by AWS: get-vanilla of the
+// Signature Version 4 test suite and the GET Object example of the S3 documentation.
+func TestSignature(t *testing.T) {
+	tests := []struct {
+		name          string
+		secret        string
+		time          string
+		region        string
+		service       string
+		uri           string
+		signedHeaders []string
+		headers       map[string]string
+		scope         string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			secret:        "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			time:          "20150830T123600Z",
+			region:        "us-east-1",
+			service:       "service",
+			uri:           "/",
+			signedHeaders: []string{"host", "x-amz-date"},
+			headers: map[string]string{
+				"host":       "example.amazonaws.com",
+				"x-amz-date": "20150830T123600Z",
+			},
+			scope:     "20150830/us-east-1/service/aws4_request",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "s3 get object",
+			secret:        "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+			time:          "20130524T000000Z",
+			region:        "us-east-1",
+			service:       "s3",
+			uri:           "/test.txt",
+			signedHeaders: []string{"host", "range", "x-amz-content-sha256", "x-amz-date"},
+			headers: map[string]string{
+				"host":                 "examplebucket.s3.amazonaws.com",
+				"range":                "bytes=0-9",
+				"x-amz-content-sha256": emptyPayloadHash,
+				"x-amz-date":           "20130524T000000Z",
+			},
+			scope:     "20130524/us-east-1/s3/aws4_request",
+			signature: "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41",
+		},
+	}
+	for _, tt := range tests {
+		now, err := time.Parse("20060102T150405Z", tt.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := canonicalRequest(http.MethodGet, tt.uri, "", tt.signedHeaders, tt.headers, emptyPayloadHash)
+		scope, sig := signature(tt.secret, now, tt.region, tt.service, request)
+		if scope != tt.scope || sig != tt.signature {
+			t.Errorf("%s: signed %s %s, want %s %s", tt.name, scope, sig, tt.scope, tt.signature)
+		}
+	}
+}
+
+var authorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/([^,]+), SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+// verify checks the request the way the storage does, from what arrived on the wire.
+func verify(r *http.Request, accessKeyID, secretAccessKey string, now time.Time) error {
+	m := authorization.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return fmt.Errorf("malformed authorization %q", r.Header.Get("Authorization"))
+	}
+	if m[1] != accessKeyID {
+		return fmt.Errorf("access key %s", m[1])
+	}
+	signedHeaders := strings.Split(m[3], ";")
+	headers := map[string]string{}
+	for _, name := range signedHeaders {
+		if name == "host" {
+			headers[name] = r.Host
+		} else {
+			headers[name] = r.Header.Get(name)
+		}
+	}
+	request := canonicalRequest(r.Method, r.RequestURI, "", signedHeaders, headers, r.Header.Get("x-amz-content-sha256"))
+	scope, sig := signature(secretAccessKey, now, "eu-west-1", "s3", request)
+	if m[2] != scope || m[4] != sig {
+		return fmt.Errorf("signature %s %s, want %s %s", m[2], m[4], scope, sig)
+	}
+	return nil
+}
+
+func TestS3Get(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RequestURI != "/base/photos/2024/a%20b%2Bc%E5%9B%BE.jpg" {
+			t.Errorf("request uri %s is not path style", r.RequestURI)
+		}
+		if r.Header.Get("x-amz-security-token") != "token" {
+			t.Errorf("session token %q", r.Header.Get("x-amz-security-token"))
+		}
+		if err := verify(r, "AKID", "secret", now); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		//先发送一部分后等待客户端读取，确认内容是边下载边返回的
+		_, _ = io.WriteString(w, "first")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s3, err := NewS3(&conf.Storage_S3{
+		Endpoint:        server.URL + "/base/",
+		Region:          "eu-west-1",
+		AccessKeyId:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.now = func() time.Time { return now }
+
+	body, err := s3.Get(context.Background(), "photos", "2024/a b+c图.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	first := make([]byte, len("first"))
+	if _, err := io.ReadFull(body, first); err != nil || string(first) != "first" {
+		t.Fatalf("read %q, %v before the object was complete", first, err)
+	}
+	release <- struct{}{}
+	rest, err := io.ReadAll(body)
+	if err != nil || string(rest) != "second" {
+		t.Fatalf("read %q, %v", rest, err)
+	}
+}
+
+func TestS3Error(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		code   int
+		reason string
+	}{
+		{http.StatusNotFound, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound, "NoSuchKey"},
+		{http.StatusNotFound, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound, "NoSuchBucket"},
+		{http.StatusForbidden, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden, "AccessDenied"},
+		{http.StatusInternalServerError, "<Error><Code>InternalError</Code></Error>", http.StatusBadGateway, "StorageError"},
+		{http.StatusServiceUnavailable, "", http.StatusBadGateway, "StorageError"},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = io.WriteString(w, tt.body)
+		}))
+		s3, err := NewS3(&conf.Storage_S3{Endpoint: server.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s3.Get(context.Background(), "bucket", "key.jpg")
+		server.Close()
+		if se := errors.FromError(err); se == nil || int(se.Code) != tt.code || se.Reason != tt.reason {
+			t.Errorf("%d %s: got %v, want %d %s", tt.status, tt.body, err, tt.code, tt.reason)
+		}
+	}
+}
+
+func TestS3InvalidKey(t *testing.T) {
+	s3, err := NewS3(&conf.Storage_S3{Endpoint: "http://127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"", "../secret", "a//b", "a/./b"} {
+		if _, err := s3.Get(context.Background(), "bucket", key); errors.Reason(err) != "InvalidObjectName" {
+			t.Errorf("key %q: got %v, want InvalidObjectName", key, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/google/wire"
+	"go-image-process/internal/conf"
+	"io"
+	"path"
+	"strings"
+)
+
+// ProviderSet is storage providers.
+var ProviderSet = wire.NewSet(NewStorage)
+
+// ErrNoSuchKey is returned when the requested object does not exist.
+var ErrNoSuchKey = errors.NotFound("NoSuchKey", "The specified key does not exist.")
+
+// Storage loads original objects, such as the images referenced by watermark image_.
+type Storage interface {
+	Get(ctx context.Context, bucket string, key string) (io.ReadCloser, error)
+}
+
+func NewStorage(bootstrap *conf.Bootstrap) (Storage, error) {
+	if len(bootstrap.GetStorage().GetS3().GetEndpoint()) > 0 {
+		return NewS3(bootstrap.GetStorage().GetS3())
+	}
+	return NewLocal(bootstrap.GetStorage().GetLocal().GetRoot()), nil
+}
+
+// validate rejects empty or nested bucket names and keys that are not clean relative paths,
+// so that a key can not escape its bucket. Backslashes are refused too, they separate paths on windows.
+func validate(bucket string, key string) error {
+	if len(bucket) == 0 || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return errors.BadRequest("InvalidBucketName", "The specified bucket is not valid.")
+	}
+	cleaned := path.Clean("/" + key)
+	if len(key) == 0 || cleaned == "/" || cleaned != "/"+key || strings.Contains(key, `\`) {
+		return errors.BadRequest("InvalidObjectName", "The specified object is not valid.")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/v2/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		bucket string
+		key    string
+		reason string
+	}{
+		{"photos", "a.jpg", ""},
+		{"photos", "2024/01/a b.jpg", ""},
+		{"photos", "a..b.jpg", ""},
+		{"", "a.jpg", "InvalidBucketName"},
+		{".", "a.jpg", "InvalidBucketName"},
+		{"..", "a.jpg", "InvalidBucketName"},
+		{"a/b", "a.jpg", "InvalidBucketName"},
+		{`a\b`, "a.jpg", "InvalidBucketName"},
+		{"photos", "", "InvalidObjectName"},
+		{"photos", "/a.jpg", "InvalidObjectName"},
+		{"photos", "a/", "InvalidObjectName"},
+		{"photos", "a//b.jpg", "InvalidObjectName"},
+		{"photos", "./a.jpg", "InvalidObjectName"},
+		{"photos", "../secret", "InvalidObjectName"},
+		{"photos", "a/../../secret", "InvalidObjectName"},
+		{"photos", `..\secret`, "InvalidObjectName"},
+		{"photos", `a\b.jpg`, "InvalidObjectName"},
+	}
+	for _, tt := range tests {
+		if reason := errors.Reason(validate(tt.bucket, tt.key)); reason != tt.reason {
+			t.Errorf("validate(%q, %q) = %q, want %q", tt.bucket, tt.key, reason, tt.reason)
+		}
+	}
+}
+
+func TestLocalGet(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "photos", "2024"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "photos", "2024", "a.jpg"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := NewLocal(root)
+
+	body, err := local.Get(context.Background(), "photos", "2024/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(content) != "image" {
+		t.Errorf("read %q, %v", content, err)
+	}
+
+	if _, err := local.Get(context.Background(), "photos", "2024/b.jpg"); errors.Reason(err) != "NoSuchKey" {
+		t.Errorf("missing object: got %v, want NoSuchKey", err)
+	}
+	if _, err := local.Get(context.Background(), "photos", "../secret"); errors.Reason(err) != "InvalidObjectName" {
+		t.Errorf("escaping key: got %v, want InvalidObjectName", err)
+	}
+}
@@ -0,0 +1,105 @@
+package style
+
+import (
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"go-image-process/internal/process"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxDepth bounds how many styles a single process string may go through.
+const maxDepth = 16
+
+// styleName follows the OSS rule for style names.
+var styleName = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+// Registry holds the named styles of image.styles. A process string is either image/<ops> or
+// style/<name>[/<ops>], the latter runs the ops of the style followed by the given ones, and the
+// content of a style may itself start with style/<name>.
+type Registry struct {
+	lock   sync.RWMutex
+	styles map[string]string
+}
+
+func NewRegistry(styles map[string]string) (*Registry, error) {
+	r := &Registry{}
+	if err := r.Update(styles); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update replaces all styles, nothing changes when one of them is invalid, its expanded operations
+// don't parse or the styles reference each other in a cycle.
+func (r *Registry) Update(styles map[string]string) error {
+	next := make(map[string]string, len(styles))
+	names := make([]string, 0, len(styles))
+	for name, content := range styles {
+		if !styleName.MatchString(name) {
+			return fmt.Errorf("invalid style name: %q", name)
+		}
+		next[name] = strings.TrimSpace(content)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		expanded, err := expand(next, "style/"+name)
+		if err == nil {
+			_, err = process.Parse(expanded)
+		}
+		if err != nil {
+			return fmt.Errorf("style %s: %s", name, errors.FromError(err).Message)
+		}
+	}
+
+	r.lock.Lock()
+	r.styles = next
+	r.lock.Unlock()
+	return nil
+}
+
+// Expand resolves the styles of process and returns the plain image/<ops> form, process strings
+// without style are returned as is.
+func (r *Registry) Expand(process string) (string, error) {
+	if !strings.HasPrefix(process, "style/") {
+		return process, nil
+	}
+	r.lock.RLock()
+	styles := r.styles
+	r.lock.RUnlock()
+	return expand(styles, process)
+}
+
+func expand(styles map[string]string, process string) (string, error) {
+	var ops []string
+	var seen []string
+	for strings.HasPrefix(process, "style/") {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(process, "style/"), "/")
+		for _, s := range seen {
+			if s == name {
+				return "", errors.BadRequest("InvalidArgument",
+					fmt.Sprintf("Style %s references itself: %s.", name, strings.Join(append(seen, name), " -> ")))
+			}
+		}
+		if len(seen) == maxDepth {
+			return "", errors.BadRequest("InvalidArgument", fmt.Sprintf("Style nesting exceeds %d levels.", maxDepth))
+		}
+		seen = append(seen, name)
+		content, ok := styles[name]
+		if !ok {
+			return "", errors.BadRequest("InvalidArgument", fmt.Sprintf("The style %s does not exist.", name))
+		}
+		//外层样式后面追加的操作在内层样式的操作之后执行
+		if len(rest) > 0 {
+			ops = append([]string{rest}, ops...)
+		}
+		process = content
+	}
+	if !strings.HasPrefix(process, "image/") {
+		return "", errors.BadRequest("InvalidArgument", fmt.Sprintf("Style %s must start with image/ or style/.", seen[len(seen)-1]))
+	}
+	return strings.Join(append([]string{process}, ops...), "/"), nil
+}
@@ -0,0 +1,98 @@
+package style
+
+import (
+	"fmt"
+	"github.com/go-kratos/kratos/v2/errors"
+	"strings"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r, err := NewRegistry(map[string]string{
+		"thumb":      "image/resize,m_fill,w_200,h_200",
+		"thumb-webp": " style/thumb/format,webp ",
+		"small":      "style/thumb-webp/quality,q_80",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestExpand(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := []struct {
+		process string
+		want    string
+	}{
+		{"image/rotate,90", "image/rotate,90"},
+		{"rotate,90", "rotate,90"},
+		{"", ""},
+		{"style/thumb", "image/resize,m_fill,w_200,h_200"},
+		{"style/thumb/rotate,90", "image/resize,m_fill,w_200,h_200/rotate,90"},
+		{"style/thumb-webp", "image/resize,m_fill,w_200,h_200/format,webp"},
+		//外层追加的操作排在内层样式之后
+		{"style/small/rotate,90", "image/resize,m_fill,w_200,h_200/format,webp/quality,q_80/rotate,90"},
+	}
+	for _, tt := range tests {
+		if got, err := r.Expand(tt.process); err != nil || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", tt.process, got, err, tt.want)
+		}
+	}
+
+	for _, process := range []string{"style/missing", "style/", "style//thumb"} {
+		if _, err := r.Expand(process); errors.Reason(err) != "InvalidArgument" {
+			t.Errorf("Expand(%q): got %v, want InvalidArgument", process, err)
+		}
+	}
+}
+
+// chain returns n styles where s0 refers to s1 and so on, the last one holds the operations.
+func chain(n int) map[string]string {
+	styles := make(map[string]string, n)
+	for i := 0; i < n-1; i++ {
+		styles[fmt.Sprintf("s%d", i)] = fmt.Sprintf("style/s%d", i+1)
+	}
+	styles[fmt.Sprintf("s%d", n-1)] = "image/rotate,90"
+	return styles
+}
+
+func TestUpdate(t *testing.T) {
+	r := newTestRegistry(t)
+	tests := []struct {
+		name   string
+		styles map[string]string
+		err    string
+	}{
+		{"empty name", map[string]string{"": "image/rotate,90"}, "invalid style name"},
+		{"invalid name", map[string]string{"a/b": "image/rotate,90"}, "invalid style name"},
+		{"self cycle", map[string]string{"a": "style/a"}, "references itself: a -> a"},
+		{"two cycle", map[string]string{"a": "style/b/rotate,90", "b": "style/a"}, "references itself"},
+		{"too deep", chain(maxDepth + 1), "exceeds 16 levels"},
+		{"unknown style", map[string]string{"a": "style/missing"}, "does not exist"},
+		{"not image", map[string]string{"a": "resize,w_100"}, "must start with image/ or style/"},
+		{"unknown operation", map[string]string{"a": "image/foo,bar"}, "style a: unknown operation foo"},
+		{"invalid appended ops", map[string]string{"a": "image/rotate,90", "b": "style/a/resize,w_0"}, "style b: w of resize"},
+	}
+	for _, tt := range tests {
+		err := r.Update(tt.styles)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.err)
+		}
+		//更新失败时保留原有样式
+		if got, err := r.Expand("style/thumb"); err != nil || got != "image/resize,m_fill,w_200,h_200" {
+			t.Errorf("%s: the old styles are gone, %q, %v", tt.name, got, err)
+		}
+	}
+
+	if err := r.Update(chain(maxDepth)); err != nil {
+		t.Fatalf("%d levels: %v", maxDepth, err)
+	}
+	if got, err := r.Expand("style/s0/format,png"); err != nil || got != "image/rotate,90/format,png" {
+		t.Errorf("Expand(style/s0/format,png) = %q, %v", got, err)
+	}
+	if _, err := r.Expand("style/thumb"); errors.Reason(err) != "InvalidArgument" {
+		t.Errorf("style/thumb is kept after the update: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+#include "fonts.h"
+
+// register_font adds the file to the fontconfig application fonts, so pango
+// can render with it, and returns its family name to be freed with g_free.
+char *register_font(const char *file) {
+  FcPattern *pattern;
+  FcChar8 *family;
+  char *result = NULL;
+  int count;
+
+  if (!FcConfigAppFontAddFile(NULL, (const FcChar8 *)file)) {
+    return NULL;
+  }
+
+  pattern = FcFreeTypeQuery((const FcChar8 *)file, 0, NULL, &count);
+  if (pattern == NULL) {
+    return NULL;
+  }
+  if (FcPatternGetString(pattern, FC_FAMILY, 0, &family) == FcResultMatch) {
+    result = g_strdup((const char *)family);
+  }
+  FcPatternDestroy(pattern);
+
+  return result;
+}
@@ -0,0 +1,61 @@
+package vips
+
+// #cgo pkg-config: fontconfig
+// #include "fonts.h"
+import "C"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// fontFamilies maps the name of the font files loaded from Config.FontDir, without extension,
+// to the family name pango knows them by.
+var fontFamilies = make(map[string]string)
+
+// FontFamily returns the family of a font registered at Startup, looked up by its file name
+// without extension, e.g. wqy-zenhei for wqy-zenhei.ttc.
+func FontFamily(name string) (string, bool) {
+	family, ok := fontFamilies[name]
+	return family, ok
+}
+
+func registerFontDir(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		govipsLog("govips", LogLevelWarning, fmt.Sprintf("failed to read font dir %s: %v", dir, err))
+		return
+	}
+
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".ttf" && ext != ".otf" && ext != ".ttc") {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+		family, err := registerFont(file)
+		if err != nil {
+			govipsLog("govips", LogLevelWarning, err.Error())
+			continue
+		}
+
+		fontFamilies[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = family
+		govipsLog("govips", LogLevelInfo, fmt.Sprintf("registered font %s as %s", file, family))
+	}
+}
+
+func registerFont(file string) (string, error) {
+	cFile := C.CString(file)
+	defer freeCString(cFile)
+
+	family := C.register_font(cFile)
+	if family == nil {
+		return "", fmt.Errorf("failed to register font %s", file)
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(family)))
+
+	return C.GoString(family), nil
+}
@@ -0,0 +1,10 @@
+// https://www.freedesktop.org/software/fontconfig/fontconfig-devel/fcconfigappfontaddfile.html
+
+// clang-format off
+// include order matters
+#include <stdlib.h>
+#include <glib.h>
+#include <fontconfig/fontconfig.h>
+// clang-format on
+
+char *register_font(const char *file);
@@ -301,6 +301,13 @@ int set_heifsave_options(VipsOperation *operation, SaveParams *params) {
     ret = vips_object_set(VIPS_OBJECT(operation), "Q", params->quality, NULL);
   }
 
+#if (VIPS_MAJOR_VERSION >= 8) && (VIPS_MINOR_VERSION >= 12)
+  if (!ret && params->effort) {
+    ret = vips_object_set(VIPS_OBJECT(operation), "effort", params->effort,
+                          NULL);
+  }
+#endif
+
   return ret;
 }
 
@@ -330,6 +337,12 @@ int set_magicksave_options(VipsOperation *operation, SaveParams *params) {
   return ret;
 }
 
+// https://www.libvips.org/API/current/VipsForeignSave.html#vips-gifsave-buffer
+int set_gifsave_options(VipsOperation *operation, SaveParams *params) {
+  return vips_object_set(VIPS_OBJECT(operation), "strip", params->stripMetadata,
+                         "interlace", params->interlace, NULL);
+}
+
 int set_png_magicksave_options(VipsOperation *operation, SaveParams *params) {
   int ret = vips_object_set(VIPS_OBJECT(operation), "format", "PNG", NULL);
   if (!ret && params->quality) {
@@ -355,6 +368,23 @@ int set_avifsave_options(VipsOperation *operation, SaveParams *params) {
   return ret;
 }
 
+// https://www.libvips.org/API/current/VipsForeignSave.html#vips-jxlsave-buffer
+int set_jxlsave_options(VipsOperation *operation, SaveParams *params) {
+  int ret = vips_object_set(VIPS_OBJECT(operation), "lossless",
+                            params->jxlLossless, NULL);
+
+  if (!ret && params->quality) {
+    ret = vips_object_set(VIPS_OBJECT(operation), "Q", params->quality, NULL);
+  }
+
+  if (!ret && params->effort) {
+    ret = vips_object_set(VIPS_OBJECT(operation), "effort", params->effort,
+                          NULL);
+  }
+
+  return ret;
+}
+
 int set_jp2ksave_options(VipsOperation *operation, SaveParams *params) {
   int ret = vips_object_set(
       VIPS_OBJECT(operation), "subsample_mode", params->jpegSubsample,
@@ -423,11 +453,22 @@ int save_to_buffer(SaveParams *params) {
     case TIFF:
       return save_buffer("tiffsave_buffer", params, set_tiffsave_options);
     case GIF:
+  #if (VIPS_MAJOR_VERSION >= 8) && (VIPS_MINOR_VERSION >= 14)
+      return save_buffer("gifsave_buffer", params, set_gifsave_options);
+  #else
       return save_buffer("magicksave_buffer", params, set_magicksave_options);
+  #endif
     case AVIF:
       return save_buffer("heifsave_buffer", params, set_avifsave_options);
     case JP2K:
       return save_buffer("jp2ksave_buffer", params, set_jp2ksave_options);
+    case JXL:
+  #if (VIPS_MAJOR_VERSION >= 8) && (VIPS_MINOR_VERSION >= 11)
+      return save_buffer("jxlsave_buffer", params, set_jxlsave_options);
+  #else
+      g_warning("jxlsave requires libvips 8.11+");
+      return 1;
+  #endif
     default:
       g_warning("Unsupported output type given: %d", params->outputFormat);
   }
@@ -495,6 +536,8 @@ static SaveParams defaultSaveParams = {
 
     .avifSpeed = 5,
 
+    .jxlLossless = FALSE,
+
     .jp2kLossless = FALSE,
     .jp2kTileHeight = 512,
     .jp2kTileWidth = 512};
@@ -45,6 +45,7 @@ const (
 	ImageTypeAVIF    ImageType = C.AVIF
 	ImageTypeJP2K    ImageType = C.JP2K
 	ImageTypeAi      ImageType = C.AI
+	ImageTypeJXL     ImageType = C.JXL
 )
 
 var imageTypeExtensionMap = map[ImageType]string{
@@ -60,6 +61,7 @@ var imageTypeExtensionMap = map[ImageType]string{
 	ImageTypeBMP:    ".bmp",
 	ImageTypeAVIF:   ".avif",
 	ImageTypeJP2K:   ".jp2",
+	ImageTypeJXL:    ".jxl",
 }
 
 // ImageTypes defines the various image types supported by govips
@@ -76,6 +78,7 @@ var ImageTypes = map[ImageType]string{
 	ImageTypeBMP:    "bmp",
 	ImageTypeAVIF:   "heif",
 	ImageTypeJP2K:   "jp2k",
+	ImageTypeJXL:    "jxl",
 }
 
 // TiffCompression represents method for compressing a tiff at export
@@ -239,6 +242,89 @@ func isJP2K(buf []byte) bool {
 	return bytes.HasPrefix(buf, jp2kHeader)
 }
 
+// jpegStdLuminanceQuantTable is the luminance table from Annex K of the JPEG spec (ITU T.81),
+// which libjpeg scales to produce the table for a given quality.
+var jpegStdLuminanceQuantTable = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// jpegQuality estimates the libjpeg quality factor a JPEG was encoded with by comparing its
+// luminance quantization table against the standard one. It returns 0 if no table is found.
+func jpegQuality(buf []byte) int {
+	if !isJPEG(buf) {
+		return 0
+	}
+	for i := 2; i+4 <= len(buf); {
+		if buf[i] != 0xFF {
+			return 0
+		}
+		marker := buf[i+1]
+		if marker == 0xFF {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xD9 || marker == 0xDA {
+			// EOI or SOS, quantization tables always come before the scan data
+			return 0
+		}
+		length := int(buf[i+2])<<8 | int(buf[i+3])
+		if length < 2 || i+2+length > len(buf) {
+			return 0
+		}
+		if marker == 0xDB {
+			segment := buf[i+4 : i+2+length]
+			for len(segment) > 0 {
+				precision, id := segment[0]>>4, segment[0]&0x0F
+				size := 64
+				if precision != 0 {
+					size = 128
+				}
+				if len(segment) < 1+size {
+					return 0
+				}
+				if id == 0 {
+					sum := 0
+					for k := 0; k < 64; k++ {
+						if precision != 0 {
+							sum += int(segment[1+2*k])<<8 | int(segment[2+2*k])
+						} else {
+							sum += int(segment[1+k])
+						}
+					}
+					return qualityFromQuantSum(sum)
+				}
+				segment = segment[1+size:]
+			}
+		}
+		i += 2 + length
+	}
+	return 0
+}
+
+// qualityFromQuantSum inverts the libjpeg quality scaling for a table whose entries add up to sum.
+func qualityFromQuantSum(sum int) int {
+	stdSum := 0
+	for _, v := range jpegStdLuminanceQuantTable {
+		stdSum += v
+	}
+	scale := float64(sum) * 100 / float64(stdSum)
+	var quality float64
+	if scale <= 100 {
+		quality = (200 - scale) / 2
+	} else {
+		quality = 5000 / scale
+	}
+	return int(math.Max(1, math.Min(100, math.Round(quality))))
+}
+
 func vipsLoadFromBuffer(buf []byte, params *ImportParams) (*C.VipsImage, ImageType, error) {
 	src := buf
 	// Reference src here so it's not garbage collected during image initialization.
@@ -289,6 +375,20 @@ func bmpToPNG(src []byte) ([]byte, error) {
 	return w.Bytes(), nil
 }
 
+func pngToBMP(src []byte) ([]byte, error) {
+	i, err := png.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	if err = bmp.Encode(&w, i); err != nil {
+		return nil, err
+	}
+
+	return w.Bytes(), nil
+}
+
 func maybeSetBoolParam(p BoolParameter, cp *C.Param) {
 	if p.IsSet() {
 		C.set_bool_param(cp, toGboolean(p.Get()))
@@ -392,6 +492,7 @@ func vipsSaveHEIFToBuffer(in *C.VipsImage, params HeifExportParams) ([]byte, err
 	p.outputFormat = C.HEIF
 	p.quality = C.int(params.Quality)
 	p.heifLossless = C.int(boolToInt(params.Lossless))
+	p.effort = C.int(params.Effort)
 
 	return vipsSaveToBuffer(p)
 }
@@ -409,6 +510,19 @@ func vipsSaveAVIFToBuffer(in *C.VipsImage, params AvifExportParams) ([]byte, err
 	return vipsSaveToBuffer(p)
 }
 
+func vipsSaveJXLToBuffer(in *C.VipsImage, params JxlExportParams) ([]byte, error) {
+	incOpCounter("save_jxl_buffer")
+
+	p := C.create_save_params(C.JXL)
+	p.inputImage = in
+	p.outputFormat = C.JXL
+	p.quality = C.int(params.Quality)
+	p.effort = C.int(params.Effort)
+	p.jxlLossless = C.int(boolToInt(params.Lossless))
+
+	return vipsSaveToBuffer(p)
+}
+
 func vipsSaveJP2KToBuffer(in *C.VipsImage, params Jp2kExportParams) ([]byte, error) {
 	incOpCounter("save_jp2k_buffer")
 
@@ -429,7 +543,9 @@ func vipsSaveGIFToBuffer(in *C.VipsImage, params GifExportParams) ([]byte, error
 
 	p := C.create_save_params(C.GIF)
 	p.inputImage = in
+	p.stripMetadata = C.int(boolToInt(params.StripMetadata))
 	p.quality = C.int(params.Quality)
+	p.interlace = C.int(boolToInt(params.Interlace))
 
 	return vipsSaveToBuffer(p)
 }
@@ -26,7 +26,8 @@ typedef enum types {
   BMP,
   AVIF,
   JP2K,
-  AI
+  AI,
+  JXL
 } ImageType;
 
 typedef enum ParamType {
@@ -120,6 +121,9 @@ typedef struct SaveParams {
   // AVIF
   int avifSpeed;
 
+  // JXL
+  BOOL jxlLossless;
+
   // JPEG2000
   BOOL jp2kLossless;
   int jp2kTileWidth;
@@ -0,0 +1,96 @@
+package vips
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	jpeg2 "image/jpeg"
+	"testing"
+)
+
+// TestJpegQuality encodes jpegs with the scaled standard tables at known qualities. Below 20 the scaled
+// entries are clipped to 255, which makes the estimate too high but still below 20.
+func TestJpegQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 100, A: 255})
+		}
+	}
+	encode := func(quality int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg2.Encode(&buf, img, &jpeg2.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, quality := range []int{20, 30, 50, 60, 75, 80, 90, 95, 99} {
+		if got := jpegQuality(encode(quality)); got != quality {
+			t.Errorf("quality %d estimated as %d", quality, got)
+		}
+	}
+	//99和100的量化表几乎全是1，无法区分
+	if got := jpegQuality(encode(100)); got < 99 {
+		t.Errorf("quality 100 estimated as %d", got)
+	}
+	for _, quality := range []int{1, 5, 10} {
+		if got := jpegQuality(encode(quality)); got < quality || got >= 20 {
+			t.Errorf("quality %d estimated as %d", quality, got)
+		}
+	}
+}
+
+func TestJpegQualityTables(t *testing.T) {
+	//16位精度的量化表，数值为标准表的两倍，对应质量25
+	wide := []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 2 + 1 + 128, 0x10}
+	for _, v := range jpegStdLuminanceQuantTable {
+		wide = append(wide, byte(v*2>>8), byte(v*2))
+	}
+	//只有色度表(id 1)时无法估计
+	chroma := append([]byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 2 + 1 + 64, 0x01}, make([]byte, 64)...)
+
+	tests := []struct {
+		name string
+		buf  []byte
+		want int
+	}{
+		{"16 bit table", wide, 25},
+		{"chrominance only", chroma, 0},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 0},
+		{"empty", nil, 0},
+		{"no table before the scan", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}, 0},
+		{"truncated table", []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x43, 0x00, 0x10}, 0},
+		{"short table", []byte{0xFF, 0xD8, 0xFF, 0xDB, 0x00, 0x04, 0x00, 0x10}, 0},
+		{"garbage", []byte{0xFF, 0xD8, 0x00, 0x00, 0x00, 0x00}, 0},
+	}
+	for _, tt := range tests {
+		if got := jpegQuality(tt.buf); got != tt.want {
+			t.Errorf("%s: estimated %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQualityFromQuantSum(t *testing.T) {
+	std := 0
+	for _, v := range jpegStdLuminanceQuantTable {
+		std += v
+	}
+	tests := []struct {
+		sum  int
+		want int
+	}{
+		{std, 50},
+		{std / 2, 75},
+		{std * 2, 25},
+		{std * 10, 5},
+		{std * 1000, 1},
+		{64, 99},
+		{0, 100},
+	}
+	for _, tt := range tests {
+		if got := qualityFromQuantSum(tt.sum); got != tt.want {
+			t.Errorf("qualityFromQuantSum(%d) = %d, want %d", tt.sum, got, tt.want)
+		}
+	}
+}
@@ -52,6 +52,8 @@ type Config struct {
 	ReportLeaks      bool
 	CacheTrace       bool
 	CollectStats     bool
+	// FontDir is a directory of ttf, otf and ttc files registered with fontconfig for text rendering
+	FontDir string
 }
 
 // Startup sets up the libvips support and ensures the versions are correct. Pass in nil for
@@ -134,6 +136,10 @@ func Startup(config *Config) {
 		if config.CacheTrace {
 			C.vips_cache_set_trace(toGboolean(true))
 		}
+
+		if len(config.FontDir) > 0 {
+			registerFontDir(config.FontDir)
+		}
 	} else {
 		C.vips_concurrency_set(defaultConcurrencyLevel)
 		C.vips_cache_set_max(defaultMaxCacheSize)
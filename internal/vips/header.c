@@ -35,6 +35,10 @@ void remove_metadata(VipsImage *in) {
   g_strfreev(fields);
 }
 
+void remove_field(VipsImage *in, const char *name) {
+  vips_image_remove(in, name);
+}
+
 int get_meta_orientation(VipsImage *in) {
   int orientation = 0;
   if (vips_image_get_typeof(in, VIPS_META_ORIENTATION) != 0) {
@@ -78,6 +82,26 @@ int get_meta_loader(const VipsImage *in, const char **out) {
   return vips_image_get_string(in, VIPS_META_LOADER, out);
 }
 
+// get_meta_string reads a string field such as the exif-ifd0-Make tags, the
+// result belongs to the image.
+int get_meta_string(const VipsImage *in, const char *name, const char **out) {
+  GType type = vips_image_get_typeof(in, name);
+  if (type != VIPS_TYPE_REF_STRING && type != G_TYPE_STRING) {
+    return -1;
+  }
+  return vips_image_get_string(in, name, out);
+}
+
+// get_meta_blob reads a blob field such as iptc-data and xmp-data, the result
+// belongs to the image.
+int get_meta_blob(VipsImage *in, const char *name, const void **out,
+                  size_t *length) {
+  if (vips_image_get_typeof(in, name) != VIPS_TYPE_BLOB) {
+    return -1;
+  }
+  return vips_image_get_blob(in, name, out, length);
+}
+
 int get_image_delay(VipsImage *in, int **out) {
   return vips_image_get_array_int(in, "delay", out, NULL);
 }
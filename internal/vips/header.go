@@ -40,6 +40,13 @@ func vipsRemoveMetadata(in *C.VipsImage) {
 	C.remove_metadata(in)
 }
 
+func vipsRemoveField(in *C.VipsImage, name string) {
+	cName := C.CString(name)
+	defer freeCString(cName)
+
+	C.remove_field(in, cName)
+}
+
 func vipsGetMetaOrientation(in *C.VipsImage) int {
 	return int(C.get_meta_orientation(in))
 }
@@ -75,6 +82,29 @@ func vipsImageGetMetaLoader(in *C.VipsImage) (string, bool) {
 	return C.GoString(out), code == 0
 }
 
+func vipsImageGetString(in *C.VipsImage, name string) (string, bool) {
+	cName := C.CString(name)
+	defer freeCString(cName)
+
+	var out *C.char
+	if code := int(C.get_meta_string(in, cName, &out)); code != 0 {
+		return "", false
+	}
+	return C.GoString(out), true
+}
+
+func vipsImageGetBlob(in *C.VipsImage, name string) ([]byte, bool) {
+	cName := C.CString(name)
+	defer freeCString(cName)
+
+	var out unsafe.Pointer
+	var length C.size_t
+	if code := int(C.get_meta_blob(in, cName, &out, &length)); code != 0 {
+		return nil, false
+	}
+	return C.GoBytes(out, C.int(length)), true
+}
+
 func vipsImageGetDelay(in *C.VipsImage, n int) ([]int, error) {
 	incOpCounter("imageGetDelay")
 	var out *C.int
@@ -11,6 +11,7 @@ char** image_get_fields(VipsImage *in);
 
 // won't remove the ICC profile
 void remove_metadata(VipsImage *in);
+void remove_field(VipsImage *in, const char *name);
 
 int get_meta_orientation(VipsImage *in);
 void remove_meta_orientation(VipsImage *in);
@@ -20,5 +21,8 @@ void set_image_n_pages(VipsImage *in, int n_pages);
 int get_page_height(VipsImage *in);
 void set_page_height(VipsImage *in, int height);
 int get_meta_loader(const VipsImage *in, const char **out);
+int get_meta_string(const VipsImage *in, const char *name, const char **out);
+int get_meta_blob(VipsImage *in, const char *name, const void **out,
+                  size_t *length);
 int get_image_delay(VipsImage *in, int **out);
 void set_image_delay(VipsImage *in, const int *array, int n);
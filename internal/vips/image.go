@@ -290,10 +290,12 @@ func NewWebpExportParams() *WebpExportParams {
 	}
 }
 
-// HeifExportParams are options when exporting a HEIF to file or buffer
+// HeifExportParams are options when exporting a HEIF to file or buffer.
+// Effort ranges from 0 (fastest) to 9 and is only honoured by libvips 8.12+, 0 keeps the libvips default.
 type HeifExportParams struct {
 	Quality  int
 	Lossless bool
+	Effort   int
 }
 
 // NewHeifExportParams creates default values for an export of a HEIF image.
@@ -301,6 +303,7 @@ func NewHeifExportParams() *HeifExportParams {
 	return &HeifExportParams{
 		Quality:  80,
 		Lossless: false,
+		Effort:   4,
 	}
 }
 
@@ -321,9 +324,12 @@ func NewTiffExportParams() *TiffExportParams {
 	}
 }
 
+// GifExportParams are options when exporting a GIF to file or buffer.
+// Interlace is only honoured by the native gif saver of libvips 8.14+.
 type GifExportParams struct {
 	StripMetadata bool
 	Quality       int
+	Interlace     bool
 }
 
 // NewGifExportParams creates default values for an export of a GIF image.
@@ -350,6 +356,22 @@ func NewAvifExportParams() *AvifExportParams {
 	}
 }
 
+// JxlExportParams are options when exporting a JPEG XL to file or buffer.
+// Effort ranges from 1 (fastest) to 9, 0 keeps the libvips default.
+type JxlExportParams struct {
+	Quality  int
+	Effort   int
+	Lossless bool
+}
+
+// NewJxlExportParams creates default values for an export of a JPEG XL image.
+func NewJxlExportParams() *JxlExportParams {
+	return &JxlExportParams{
+		Quality: 75,
+		Effort:  7,
+	}
+}
+
 // Jp2kExportParams are options when exporting an JPEG2000 to file or buffer.
 type Jp2kExportParams struct {
 	Quality       int
@@ -419,6 +441,19 @@ func LoadImageFromBuffer(buf []byte, params *ImportParams) (*ImageRef, error) {
 	return ref, nil
 }
 
+// NewTextImage renders the text into a new sRGB image, the glyphs are painted with the given color
+// and their anti-aliased coverage, scaled by the opacity, becomes the alpha channel.
+func NewTextImage(params *TextParams) (*ImageRef, error) {
+	startupIfNeeded()
+
+	vipsImage, err := vipsTextImage(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return newImageRef(vipsImage, ImageTypeUnknown, nil), nil
+}
+
 // NewThumbnailFromFile loads an image from file and creates a new ImageRef with thumbnail crop
 func NewThumbnailFromFile(file string, width, height int, crop Interesting) (*ImageRef, error) {
 	return LoadThumbnailFromFile(file, width, height, crop, SizeBoth, nil)
@@ -547,6 +582,15 @@ func (r *ImageRef) Format() ImageType {
 	return r.format
 }
 
+// JpegQuality estimates the quality of the source buffer if it was loaded from a JPEG.
+// It returns 0 for other formats or when the quantization table can't be read.
+func (r *ImageRef) JpegQuality() int {
+	if r.format != ImageTypeJPEG {
+		return 0
+	}
+	return jpegQuality(r.buf)
+}
+
 // Width returns the width of this image.
 func (r *ImageRef) Width() int {
 	return int(r.image.Xsize)
@@ -738,6 +782,92 @@ func (r *ImageRef) SetPageDelay(delay []int) error {
 	return vipsImageSetDelay(r.image, data)
 }
 
+// ForEachPage runs fn on every page of a multi-page image separately, e.g. on each frame of an animated gif,
+// and joins the results back into one image. fn must give every page the same size.
+// A single page image is passed to fn as is.
+func (r *ImageRef) ForEachPage(fn func(page *ImageRef) error) error {
+	pageHeight := r.PageHeight()
+	pages := r.Height() / pageHeight
+	if pages <= 1 {
+		return fn(r)
+	}
+
+	refs := make([]*ImageRef, 0, pages)
+	defer func() {
+		for _, ref := range refs {
+			ref.Close()
+		}
+	}()
+
+	images := make([]*C.VipsImage, 0, pages)
+	for i := 0; i < pages; i++ {
+		page, err := r.page(i, pageHeight)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, page)
+
+		if err := fn(page); err != nil {
+			return err
+		}
+		if page.Width() != refs[0].Width() || page.Height() != refs[0].Height() {
+			return fmt.Errorf("page %d is %dx%d, expected %dx%d", i, page.Width(), page.Height(),
+				refs[0].Width(), refs[0].Height())
+		}
+		images = append(images, page.image)
+	}
+
+	joined, err := vipsArrayJoin(images, 1)
+	if err != nil {
+		return err
+	}
+	defer clearImage(joined)
+
+	out, err := vipsCopyImage(joined)
+	if err != nil {
+		return err
+	}
+	vipsSetPageHeight(out, refs[0].Height())
+	vipsSetImageNPages(out, pages)
+
+	r.setImage(out)
+	return nil
+}
+
+// ExtractPage keeps only the given page of a multi-page image, e.g. the first frame of an animated gif.
+func (r *ImageRef) ExtractPage(n int) error {
+	pageHeight := r.PageHeight()
+	if n < 0 || (n+1)*pageHeight > r.Height() {
+		return fmt.Errorf("page %d out of range", n)
+	}
+
+	page, err := r.page(n, pageHeight)
+	if err != nil {
+		return err
+	}
+	r.setImage(page.image)
+	page.image = nil
+	return nil
+}
+
+// page returns page n as a single page image.
+func (r *ImageRef) page(n int, pageHeight int) (*ImageRef, error) {
+	extracted, err := vipsExtractArea(r.image, 0, n*pageHeight, r.Width(), pageHeight)
+	if err != nil {
+		return nil, err
+	}
+	defer clearImage(extracted)
+
+	out, err := vipsCopyImage(extracted)
+	if err != nil {
+		return nil, err
+	}
+	vipsSetPageHeight(out, pageHeight)
+	vipsSetImageNPages(out, 1)
+
+	return newImageRef(out, r.format, nil), nil
+}
+
 // Export creates a byte array of the image for use.
 // The function returns a byte array that can be written to a file e.g. via ioutil.WriteFile().
 // N.B. govips does not currently have built-in support for directly exporting to a file.
@@ -935,6 +1065,36 @@ func (r *ImageRef) ExportAvif(params *AvifExportParams) ([]byte, *ImageMetadata,
 	return buf, r.newMetadata(ImageTypeAVIF), nil
 }
 
+// ExportBmp exports the image as BMP to a buffer. libvips has no bmp saver, so the image is
+// written as an uncompressed png first and re-encoded by golang.org/x/image/bmp.
+func (r *ImageRef) ExportBmp() ([]byte, *ImageMetadata, error) {
+	buf, err := vipsSavePNGToBuffer(r.image, PngExportParams{Compression: 0})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buf, err = pngToBMP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf, r.newMetadata(ImageTypeBMP), nil
+}
+
+// ExportJxl exports the image as JPEG XL to a buffer.
+func (r *ImageRef) ExportJxl(params *JxlExportParams) ([]byte, *ImageMetadata, error) {
+	if params == nil {
+		params = NewJxlExportParams()
+	}
+
+	buf, err := vipsSaveJXLToBuffer(r.image, *params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf, r.newMetadata(ImageTypeJXL), nil
+}
+
 // ExportJp2k exports the image as JPEG2000 to a buffer.
 func (r *ImageRef) ExportJp2k(params *Jp2kExportParams) ([]byte, *ImageMetadata, error) {
 	if params == nil {
@@ -1314,10 +1474,41 @@ func (r *ImageRef) RemoveMetadata() error {
 	return nil
 }
 
+// RemoveFields removes the metadata fields, such as exif-ifd3-GPSLatitude, for which remove returns true.
+// Exif tags removed this way are also dropped from the exif block written by the encoders.
+func (r *ImageRef) RemoveFields(remove func(field string) bool) error {
+	out, err := vipsCopyImage(r.image)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range vipsImageGetFields(out) {
+		if remove(field) {
+			vipsRemoveField(out, field)
+		}
+	}
+
+	r.setImage(out)
+
+	return nil
+}
+
 func (r *ImageRef) ImageFields() []string {
 	return vipsImageGetFields(r.image)
 }
 
+// GetString returns the value of a string metadata field, such as exif-ifd0-Make. The second value
+// is false when the field does not exist or is not a string.
+func (r *ImageRef) GetString(name string) (string, bool) {
+	return vipsImageGetString(r.image, name)
+}
+
+// GetBlob returns a copy of a blob metadata field, such as iptc-data or xmp-data. The second value
+// is false when the field does not exist or is not a blob.
+func (r *ImageRef) GetBlob(name string) ([]byte, bool) {
+	return vipsImageGetBlob(r.image, name)
+}
+
 func (r *ImageRef) HasExif() bool {
 	for _, field := range r.ImageFields() {
 		if strings.HasPrefix(field, "exif-") {
@@ -1,5 +1,6 @@
 
 #include "label.h"
+#include <math.h>
 #include <stdio.h>
 
 int text(VipsImage **out, const char *text, const char *font, int width,
@@ -86,6 +87,95 @@ int vips_watermark(VipsImage *in, VipsImage **out, WatermarkOptions *o) {
 	return 0;
 }
 
+// paint turns a one band mask into an sRGB image of the given colour, with the
+// mask scaled by opacity as its alpha band.
+static int paint(VipsObject *scope, VipsImage *mask, VipsImage **out,
+                 double *color, double opacity) {
+  double ones[3] = {1, 1, 1};
+  VipsImage **t = (VipsImage **)vips_object_local_array(scope, 6);
+  if (vips_linear1(mask, &t[0], opacity, 0.0, NULL) ||
+      vips_cast(t[0], &t[1], VIPS_FORMAT_UCHAR, NULL) ||
+      vips_black(&t[2], mask->Xsize, mask->Ysize, "bands", 3, NULL) ||
+      vips_linear(t[2], &t[3], ones, color, 3, NULL) ||
+      vips_cast(t[3], &t[4], VIPS_FORMAT_UCHAR, NULL) ||
+      vips_copy(t[4], &t[5], "interpretation", VIPS_INTERPRETATION_sRGB,
+                NULL) ||
+      vips_bandjoin2(t[5], t[1], out, NULL)) {
+    return 1;
+  }
+  return 0;
+}
+
+int text_image(VipsImage **out, TextImageOptions *o) {
+  double black[3] = {0, 0, 0};
+  double sigma = VIPS_MAX(1, o->ShadowOffset / 2.0);
+  int shadow = o->ShadowOpacity > 0;
+  int pad =
+      o->StrokeWidth + (shadow ? o->ShadowOffset + (int)ceil(3 * sigma) : 0);
+  int size = 2 * o->StrokeWidth + 1;
+  int modes[2] = {VIPS_BLEND_MODE_OVER, VIPS_BLEND_MODE_OVER};
+  VipsImage *layers[3];
+  int n = 0;
+  VipsImage *outline;
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **)vips_object_local_array(VIPS_OBJECT(base), 9);
+
+  // The glyph mask, with room around it for the stroke and the shadow.
+  if (vips_text(&t[0], o->Text, "font", o->Font, "dpi", o->DPI, NULL) ||
+      vips_rotate(t[0], &t[1], o->Rotate, NULL) ||
+      vips_embed(t[1], &t[2], pad, pad, t[1]->Xsize + 2 * pad,
+                 t[1]->Ysize + 2 * pad, NULL)) {
+    g_object_unref(base);
+    return 1;
+  }
+  outline = t[2];
+
+  // The stroke is the mask dilated by StrokeWidth pixels.
+  if (o->StrokeWidth > 0) {
+    if (vips_rank(t[2], &t[3], size, size, size * size - 1, NULL) ||
+        paint(VIPS_OBJECT(base), t[3], &t[4], o->StrokeColor, o->Opacity)) {
+      g_object_unref(base);
+      return 1;
+    }
+    outline = t[3];
+  }
+
+  // The shadow is a blurred copy of the outline moved to the bottom right.
+  if (shadow) {
+    if (vips_gaussblur(outline, &t[5], sigma, NULL) ||
+        vips_embed(t[5], &t[6], o->ShadowOffset, o->ShadowOffset,
+                   t[5]->Xsize, t[5]->Ysize, NULL) ||
+        paint(VIPS_OBJECT(base), t[6], &t[7], black,
+              o->Opacity * o->ShadowOpacity)) {
+      g_object_unref(base);
+      return 1;
+    }
+    layers[n++] = t[7];
+  }
+  if (o->StrokeWidth > 0) {
+    layers[n++] = t[4];
+  }
+
+  if (paint(VIPS_OBJECT(base), t[2], &t[8], o->Color, o->Opacity)) {
+    g_object_unref(base);
+    return 1;
+  }
+  layers[n++] = t[8];
+
+  if (n == 1) {
+    if (vips_copy(t[8], out, NULL)) {
+      g_object_unref(base);
+      return 1;
+    }
+  } else if (vips_composite(layers, out, n, modes, NULL)) {
+    g_object_unref(base);
+    return 1;
+  }
+
+  g_object_unref(base);
+  return 0;
+}
+
 int label(VipsImage *in, VipsImage **out, LabelOptions *o) {
   double ones[3] = {1, 1, 1};
   VipsImage *base = vips_image_new();
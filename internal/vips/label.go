@@ -70,6 +70,64 @@ type vipsWatermarkOptions struct {
 	Background  [3]C.double
 }
 
+// TextParams represents a text rendered into an image of its own, optionally with a stroke of
+// StrokeWidth pixels around the glyphs and a black drop shadow moved ShadowOffset pixels to the bottom right
+type TextParams struct {
+	Text          string
+	Font          string
+	DPI           int
+	Rotate        int
+	Opacity       float32
+	Color         Color
+	ShadowOpacity float32
+	ShadowOffset  int
+	StrokeWidth   int
+	StrokeColor   Color
+}
+
+type vipsTextImageOptions struct {
+	Text          *C.char
+	Font          *C.char
+	DPI           C.int
+	Rotate        C.int
+	Opacity       C.float
+	Color         [3]C.double
+	ShadowOpacity C.float
+	ShadowOffset  C.int
+	StrokeWidth   C.int
+	StrokeColor   [3]C.double
+}
+
+func vipsTextImage(params *TextParams) (*C.VipsImage, error) {
+	incOpCounter("text")
+	var out *C.VipsImage
+
+	text := C.CString(params.Text)
+	defer freeCString(text)
+
+	font := C.CString(params.Font)
+	defer freeCString(font)
+
+	opts := vipsTextImageOptions{
+		Text:          text,
+		Font:          font,
+		DPI:           C.int(params.DPI),
+		Rotate:        C.int(params.Rotate),
+		Opacity:       C.float(params.Opacity),
+		Color:         [3]C.double{C.double(params.Color.R), C.double(params.Color.G), C.double(params.Color.B)},
+		ShadowOpacity: C.float(params.ShadowOpacity),
+		ShadowOffset:  C.int(params.ShadowOffset),
+		StrokeWidth:   C.int(params.StrokeWidth),
+		StrokeColor:   [3]C.double{C.double(params.StrokeColor.R), C.double(params.StrokeColor.G), C.double(params.StrokeColor.B)},
+	}
+
+	if err := C.text_image(&out, (*C.TextImageOptions)(unsafe.Pointer(&opts))); err != 0 {
+		return nil, handleImageError(out)
+	}
+
+	return out, nil
+}
+
 func vipsWatermark(image *C.VipsImage, w Watermark) (*C.VipsImage, error) {
 	var out *C.VipsImage
 
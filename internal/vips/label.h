@@ -28,8 +28,23 @@ typedef struct {
 	double Background[3];
 } WatermarkOptions;
 
+typedef struct {
+  const char *Text;
+  const char *Font;
+  int DPI;
+  int Rotate;
+  float Opacity;
+  double Color[3];
+  float ShadowOpacity;
+  int ShadowOffset;
+  int StrokeWidth;
+  double StrokeColor[3];
+} TextImageOptions;
+
 int vips_watermark(VipsImage *in, VipsImage **out, WatermarkOptions *o);
 
+int text_image(VipsImage **out, TextImageOptions *o);
+
 int label(VipsImage *in, VipsImage **out, LabelOptions *o);
 
 int text(VipsImage **out, const char *text, const char *font, int width,